@@ -0,0 +1,243 @@
+/*
+ * cache.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/billziss-gh/golib/appdata"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+func init() {
+	commands["cache"] = command{cache, "[-o config.dir=DIR] list|gc|rm [-f] name...",
+		"list cached repositories, remove stale directories or remove repository caches"}
+	commands["scrub"] = command{scrub, "[dir...]",
+		"verify cached objects and remove corrupted ones (default dir: cache root)"}
+}
+
+/* cacheRoot returns the directory that contains the default cache directories */
+func cacheRoot() (string, error) {
+	d, err := appdata.CacheDir()
+	if nil != err {
+		return "", err
+	}
+	p, err := os.Executable()
+	if nil != err {
+		return "", err
+	}
+	return filepath.Join(d, strings.TrimSuffix(filepath.Base(p), ".exe")), nil
+}
+
+func cache(args []string) int {
+	options := util.Optlist{}
+
+	flags := flag.NewFlagSet(progname+" cache", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s cache %s\n\n", progname, commands["cache"].args)
+		fmt.Fprintf(os.Stderr, ""+
+			"  list  list cached repositories with their sizes and kept overlays\n"+
+			"  gc    remove directories left behind by interrupted cache removals and\n"+
+			"        metadata whose repository cache directory no longer exists\n"+
+			"  rm    remove the caches of repositories (ident/owner/repo for the default\n"+
+			"        cache location); -f removes repositories with kept overlays\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Var(&options, "o", "config `options` (config.dir=DIR); default: default cache location")
+	if nil != flags.Parse(args) {
+		return 2
+	}
+
+	root := ""
+	for _, o := range options {
+		for _, s := range strings.Split(o, ",") {
+			if strings.HasPrefix(s, "config.dir=") && ":" != s[len("config.dir="):] {
+				root = s[len("config.dir="):]
+			}
+		}
+	}
+	idents := "" == root
+	if idents {
+		var err error
+		root, err = cacheRoot()
+		if nil != err {
+			warn("cache error: %v", err)
+			return 1
+		}
+	}
+
+	args = flags.Args()
+	if 0 == len(args) {
+		flags.Usage()
+		return 2
+	}
+	switch args[0] {
+	case "list":
+		if 1 != len(args) {
+			flags.Usage()
+			return 2
+		}
+		return cacheList(root, idents)
+	case "gc":
+		if 1 != len(args) {
+			flags.Usage()
+			return 2
+		}
+		count, err := prov.CollectCache(root, idents, func(path string) {
+			fmt.Printf("removed %s\n", path)
+		})
+		if nil != err {
+			warn("cache error: %v", err)
+			return 1
+		}
+		fmt.Printf("%s: %d stale directories or orphaned metadata removed\n", root, count)
+		return 0
+	case "rm":
+		force := false
+		args = args[1:]
+		if 0 < len(args) && "-f" == args[0] {
+			force = true
+			args = args[1:]
+		}
+		if 0 == len(args) {
+			flags.Usage()
+			return 2
+		}
+		return cacheRemove(root, idents, force, args)
+	default:
+		flags.Usage()
+		return 2
+	}
+}
+
+func cacheName(r *prov.CacheRepository) string {
+	if "" == r.Ident {
+		return r.Owner + "/" + r.Name
+	}
+	return r.Ident + "/" + r.Owner + "/" + r.Name
+}
+
+func cacheSize(size int64) string {
+	units := "KMGT"
+	if 1024 > size {
+		return fmt.Sprintf("%dB", size)
+	}
+	f := float64(size) / 1024
+	i := 0
+	for ; 1024 <= f && len(units)-1 > i; i++ {
+		f /= 1024
+	}
+	return fmt.Sprintf("%.1f%c", f, units[i])
+}
+
+func cacheList(root string, idents bool) int {
+	lst, err := prov.ListCache(root, idents)
+	if nil != err {
+		warn("cache error: %v", err)
+		return 1
+	}
+
+	total := int64(0)
+	for _, r := range lst {
+		keep := ""
+		if 0 != len(r.Keep) {
+			keep = "  keep: " + strings.Join(r.Keep, ",")
+		} else if r.Orphan {
+			keep = "  metadata only"
+		}
+		fmt.Printf("%8s  %s%s\n", cacheSize(r.Size), cacheName(r), keep)
+		total += r.Size
+	}
+	fmt.Printf("%8s  total (%d repositories in %s)\n", cacheSize(total), len(lst), root)
+
+	return 0
+}
+
+func cacheRemove(root string, idents bool, force bool, names []string) int {
+	lst, err := prov.ListCache(root, idents)
+	if nil != err {
+		warn("cache error: %v", err)
+		return 1
+	}
+
+	ec := 0
+	for _, n := range names {
+		var found *prov.CacheRepository
+		for _, r := range lst {
+			if n == cacheName(r) {
+				found = r
+				break
+			}
+		}
+		if nil == found {
+			warn("%s: not found in cache", n)
+			ec = 1
+			continue
+		}
+		if 0 != len(found.Keep) && !force {
+			warn("%s: has kept overlays (%s); use -f to remove", n, strings.Join(found.Keep, ","))
+			ec = 1
+			continue
+		}
+		err = prov.RemoveCache(root, idents, found.Ident, found.Owner, found.Name)
+		if nil != err {
+			warn("%s: %v", n, err)
+			ec = 1
+			continue
+		}
+		fmt.Printf("removed %s\n", found.Path)
+	}
+
+	return ec
+}
+
+func scrub(args []string) int {
+	flags := flag.NewFlagSet(progname+" scrub", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s scrub %s\n", progname, commands["scrub"].args)
+	}
+	if nil != flags.Parse(args) {
+		return 2
+	}
+
+	dirs := flags.Args()
+	if 0 == len(dirs) {
+		root, err := cacheRoot()
+		if nil != err {
+			warn("cache error: %v", err)
+			return 1
+		}
+		dirs = []string{root}
+	}
+
+	ec := 0
+	for _, dir := range dirs {
+		count, corrupted, err := prov.ScrubCache(dir, func(path string) {
+			fmt.Printf("removed corrupted object %s\n", path)
+		})
+		if nil != err {
+			warn("scrub error: %v", err)
+			ec = 1
+			continue
+		}
+		fmt.Printf("%s: %d objects verified, %d corrupted objects removed\n", dir, count, corrupted)
+	}
+
+	return ec
+}
@@ -0,0 +1,203 @@
+/*
+ * diff.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	pathutil "path"
+	"sort"
+
+	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/git"
+	"github.com/winfsp/hubfs/prov"
+)
+
+func init() {
+	commands["diff"] = command{diff, "[options] [remote] owner/repo@ref",
+		"write the changes made to a ref directory as a patch (for git apply)"}
+}
+
+/* entryContent returns the content of a tree entry as it appears in a patch and its hash */
+/* (an LFS file has the content of its LFS object, as in a checkout, and the hash of that) */
+func entryContent(repository prov.Repository, entry prov.TreeEntry) ([]byte, string, error) {
+	switch entry.Mode() {
+	case 0120000:
+		return []byte(entry.Target()), entry.Hash(), nil
+	case 0160000:
+		return []byte("Subproject commit " + entry.Hash() + "\n"), entry.Hash(), nil
+	}
+	reader, err := repository.GetBlobReader(entry)
+	if nil != err {
+		return nil, "", err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	content, err := ioutil.ReadAll(io.NewSectionReader(reader, 0, entry.Size()))
+	if nil != err {
+		return nil, "", err
+	}
+	hash, _ := git.NewObject(git.BlobObject, content)
+	return content, hash, nil
+}
+
+/* patchFiles converts changes to the files of a patch; deleted directories are expanded */
+func patchFiles(repository prov.Repository, ref prov.Ref, changes []*prov.Change) (
+	res []*git.PatchFile, err error) {
+	var deleted func(path string, entry prov.TreeEntry) error
+	deleted = func(path string, entry prov.TreeEntry) error {
+		if 0040000 == entry.Mode() {
+			lst, err := repository.GetTree(ref, entry)
+			if nil != err {
+				return err
+			}
+			sort.Slice(lst, func(i, j int) bool { return lst[i].Name() < lst[j].Name() })
+			for _, e := range lst {
+				err = deleted(pathutil.Join(path, e.Name()), e)
+				if nil != err {
+					return err
+				}
+			}
+			return nil
+		}
+		content, hash, err := entryContent(repository, entry)
+		if nil != err {
+			return err
+		}
+		res = append(res, &git.PatchFile{
+			Path:    path,
+			OldMode: entry.Mode(),
+			OldHash: hash,
+			Old:     content,
+		})
+		return nil
+	}
+
+	for _, c := range changes {
+		if prov.ChangeDeleted == c.Kind || prov.ChangeOpaque == c.Kind {
+			err = deleted(c.Path, c.Entry)
+			if nil != err {
+				return
+			}
+			continue
+		}
+
+		f := &git.PatchFile{
+			Path:    c.Path,
+			NewMode: c.Mode,
+			NewHash: c.Hash,
+		}
+		if 0120000 == c.Mode {
+			f.New = []byte(c.Target)
+		} else {
+			f.New, err = ioutil.ReadFile(c.File)
+			if nil != err {
+				return
+			}
+		}
+		if nil != c.Entry {
+			f.OldMode = c.Entry.Mode()
+			f.Old, f.OldHash, err = entryContent(repository, c.Entry)
+			if nil != err {
+				return
+			}
+		}
+		res = append(res, f)
+	}
+
+	return
+}
+
+func diff(args []string) int {
+	var refopt refOptions
+	output := ""
+	remote := "github.com"
+	spec := ""
+
+	flags := flag.NewFlagSet(progname+" diff", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s diff %s\n\n", progname, commands["diff"].args)
+		flags.PrintDefaults()
+	}
+	refopt.addFlags(flags)
+	flags.StringVar(&output, "output", output, "write patch to `file`; default: standard output")
+	if nil != flags.Parse(args) {
+		return 2
+	}
+
+	switch flags.NArg() {
+	case 1:
+		spec = flags.Arg(0)
+	case 2:
+		remote = flags.Arg(0)
+		spec = flags.Arg(1)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if _, _, _, path, ok := parseRefSpec(spec); !ok || "" != path {
+		flags.Usage()
+		return 2
+	}
+
+	rs, ok := refopt.openRefSpec(remote, spec)
+	if !ok {
+		return 1
+	}
+	defer rs.close()
+
+	var changes []*prov.Change
+	base, err := hubfs.OverlayBase(rs.repository, rs.ref)
+	if nil == err {
+		changes, err = hubfs.OverlayChanges(rs.repository, rs.ref, base, rs.caseins)
+	}
+	if nil != err {
+		warn("overlay error: %v", err)
+		return 1
+	}
+	var files []*git.PatchFile
+	baseref, err := hubfs.OverlayBaseRef(rs.repository, rs.ref, base)
+	if nil == err {
+		files, err = patchFiles(rs.repository, baseref, changes)
+	}
+	if nil != err {
+		warn("diff error: %v", err)
+		return 1
+	}
+
+	w := os.Stdout
+	if "" != output {
+		w, err = os.Create(output)
+		if nil != err {
+			warn("diff error: %v", err)
+			return 1
+		}
+		defer w.Close()
+	}
+	/* the patch applies to the commit that the changes were made on (ignored by git apply) */
+	_, err = fmt.Fprintf(w, "base-commit: %s\n\n", base)
+	if nil == err {
+		err = git.EncodePatch(w, files)
+	}
+	if nil != err {
+		warn("diff error: %v", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,278 @@
+/*
+ * changes.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/winfsp/hubfs/fs/port"
+	"github.com/winfsp/hubfs/fs/ptfs"
+	"github.com/winfsp/hubfs/fs/unionfs"
+	"github.com/winfsp/hubfs/git"
+	"github.com/winfsp/hubfs/prov"
+)
+
+// pathmapName is the name of the path map file in the upper layer of an overlay.
+const pathmapName = ".unionfs"
+
+// keepName is the name of the file that is created in the upper layer of an overlay when
+// it is first written. It records the commit of the ref at that time (the base commit).
+const keepName = ".keep"
+
+// OverlayDirectory returns the directory of the upper layer of the overlay of a ref.
+func OverlayDirectory(repository prov.Repository, ref prov.Ref) string {
+	return filepath.Join(repository.GetDirectory(), "files", ref.Name())
+}
+
+// OverlayBase returns the base commit of the overlay of a ref, i.e. the commit that the
+// changes in the overlay were made on. If the overlay does not record it (e.g. because
+// it has not been written yet), the current commit of the ref is returned.
+func OverlayBase(repository prov.Repository, ref prov.Ref) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(OverlayDirectory(repository, ref), keepName))
+	if nil == err {
+		if base := strings.TrimSpace(string(content)); 40 == len(base) {
+			return base, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	gitdir, err := repository.GetGitDir(ref)
+	if nil != err {
+		return "", err
+	}
+	return gitdir.Commit, nil
+}
+
+// OverlayBaseRef returns a ref for the base commit of the overlay of a ref: the ref itself
+// if it is still at the base commit or a temporary ref for the base commit.
+func OverlayBaseRef(repository prov.Repository, ref prov.Ref, base string) (prov.Ref, error) {
+	gitdir, err := repository.GetGitDir(ref)
+	if nil != err {
+		return nil, err
+	}
+	if base == gitdir.Commit {
+		return ref, nil
+	}
+	return repository.GetTempRef(base)
+}
+
+// SetOverlayBase records the base commit of the overlay of a ref (e.g. after the changes
+// in the overlay have been pushed).
+func SetOverlayBase(repository prov.Repository, ref prov.Ref, commit string) error {
+	root := OverlayDirectory(repository, ref)
+	if _, err := os.Stat(root); nil != err {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(root, keepName), []byte(commit+"\n"), 0644)
+}
+
+// OverlayChanges returns the changes that the overlay of a ref makes to the tree of its
+// base commit (see OverlayBase). Added and modified files are found in the upper layer
+// of the overlay; deleted files and directories are found in the path map, which records
+// whiteouts and opaque (i.e. deleted and recreated) directories. The files in an opaque
+// directory are reported as added. Empty directories are not reported.
+func OverlayChanges(repository prov.Repository, ref prov.Ref, base string, caseins bool) (
+	res []*prov.Change, err error) {
+	root := OverlayDirectory(repository, ref)
+	if _, err = os.Stat(root); nil != err {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	/* the changes are relative to the tree of the base commit (the ref may have moved) */
+	ref, err = OverlayBaseRef(repository, ref, base)
+	if nil != err {
+		return
+	}
+	errc, realroot := port.Realpath(root)
+	if 0 != errc {
+		return nil, fmt.Errorf("%s: cannot resolve path (errc=%d)", root, errc)
+	}
+	root = realroot
+
+	var pm *unionfs.Pathmap
+	if _, e := os.Stat(filepath.Join(root, pathmapName)); nil == e {
+		errc, pm = unionfs.OpenPathmap(ptfs.New(root), "/"+pathmapName, caseins)
+	} else {
+		errc, pm = unionfs.OpenPathmap(nil, "", caseins)
+	}
+	if 0 != errc {
+		return nil, fmt.Errorf("%s: cannot read path map (errc=%d)", root, errc)
+	}
+	defer pm.Close()
+
+	key := func(name string) string {
+		if caseins {
+			return strings.ToUpper(name)
+		}
+		return name
+	}
+	isdir := func(e prov.TreeEntry) bool {
+		return nil != e && 0040000 == e.Mode()
+	}
+
+	var walk func(dir string, lower []prov.TreeEntry) error
+	walk = func(dir string, lower []prov.TreeEntry) error {
+		lowermap := make(map[string]prov.TreeEntry, len(lower))
+		for _, e := range lower {
+			lowermap[key(e.Name())] = e
+		}
+		uppermap := make(map[string]os.FileInfo)
+		infos, _ := ioutil.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+		for _, info := range infos {
+			if "" == dir {
+				/* skip the files of the overlay and the virtual files of the ref directory */
+				if pathmapName == info.Name() || keepName == info.Name() ||
+					strings.EqualFold(gitdirName, info.Name()) {
+					continue
+				}
+				if _, ok := lowermap[key(resetName)]; !ok && key(resetName) == key(info.Name()) {
+					continue
+				}
+			}
+			uppermap[key(info.Name())] = info
+		}
+
+		names := make(map[string]string, len(lowermap)+len(uppermap))
+		for k, e := range lowermap {
+			names[e.Name()] = k
+		}
+		for k, info := range uppermap {
+			if _, ok := lowermap[k]; !ok {
+				names[info.Name()] = k
+			}
+		}
+		sorted := make([]string, 0, len(names))
+		for n := range names {
+			sorted = append(sorted, n)
+		}
+		sort.Strings(sorted)
+
+		for _, name := range sorted {
+			k := names[name]
+			e, info := lowermap[k], uppermap[k]
+			path := pathutil.Join(dir, name)
+			isopq, v := pm.Get("/" + path)
+
+			switch {
+			case unionfs.WHITEOUT == v:
+				if nil != e {
+					res = append(res, &prov.Change{Path: path, Kind: prov.ChangeDeleted, Entry: e})
+				}
+			case nil != info && (0 == v || unionfs.UNKNOWN == v):
+				if info.IsDir() {
+					var sub []prov.TreeEntry
+					if isdir(e) && isopq {
+						res = append(res, &prov.Change{Path: path, Kind: prov.ChangeOpaque, Entry: e})
+					} else if isdir(e) {
+						var err error
+						sub, err = repository.GetTree(ref, e)
+						if nil != err {
+							return err
+						}
+					} else if nil != e {
+						res = append(res, &prov.Change{Path: path, Kind: prov.ChangeDeleted, Entry: e})
+					}
+					err := walk(path, sub)
+					if nil != err {
+						return err
+					}
+					continue
+				}
+				if isdir(e) {
+					res = append(res, &prov.Change{Path: path, Kind: prov.ChangeDeleted, Entry: e})
+					e = nil
+				}
+				change, err := overlayChange(filepath.Join(root, filepath.FromSlash(path)), info, e)
+				if nil != err {
+					return err
+				}
+				if nil != change {
+					change.Path = path
+					res = append(res, change)
+				}
+			}
+		}
+
+		return nil
+	}
+
+	lower, err := repository.GetTree(ref, nil)
+	if nil != err {
+		return
+	}
+	err = walk("", lower)
+	if nil != err {
+		return nil, err
+	}
+
+	return
+}
+
+// overlayChange compares a file in the upper layer of an overlay with the corresponding
+// tree entry (nil if there is none) and returns the change or nil if they are the same.
+func overlayChange(path string, info os.FileInfo, entry prov.TreeEntry) (*prov.Change, error) {
+	kind := prov.ChangeAdded
+	if nil != entry {
+		kind = prov.ChangeModified
+	}
+
+	if 0 != info.Mode()&os.ModeSymlink {
+		target, err := os.Readlink(path)
+		if nil != err {
+			return nil, err
+		}
+		if nil != entry && (0120000 == entry.Mode() || 0160000 == entry.Mode()) &&
+			target == entry.Target() {
+			return nil, nil
+		}
+		hash, _ := git.NewObject(git.BlobObject, []byte(target))
+		return &prov.Change{Kind: kind, Entry: entry, Mode: 0120000, Hash: hash, Target: target}, nil
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	var mode uint32 = 0100644
+	if "windows" == runtime.GOOS {
+		// file modes do not record the executable bit on Windows; keep that of the ref
+		if nil != entry && 0100755 == entry.Mode() {
+			mode = 0100755
+		}
+	} else if 0 != info.Mode()&0111 {
+		mode = 0100755
+	}
+
+	hash, same, err := prov.HashFile(path, entry)
+	if nil != err {
+		return nil, err
+	}
+	if same && mode == entry.Mode() {
+		return nil, nil
+	}
+	return &prov.Change{Kind: kind, Entry: entry, Mode: mode, Hash: hash, File: path}, nil
+}
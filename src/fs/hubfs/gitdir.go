@@ -0,0 +1,353 @@
+/*
+ * gitdir.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"container/list"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/prov"
+)
+
+// Every ref directory has a virtual read-only .git directory, so that git commands that
+// read the repository (e.g. git rev-parse HEAD, git log, git describe, git status) work
+// inside a ref directory. The .git directory is not listed. It contains:
+//
+// - HEAD: the branch of the ref directory or the commit of any other ref.
+// - config: the remote and settings that make git compare files by size and mtime only.
+// - packed-refs: the branches and tags of the repository.
+// - index: the tree of the ref, with stat data that matches the files in the ref
+//   directory, so that unmodified files are not read. The index requires a walk of the
+//   entire tree; it is built lazily when it is opened (or when its size is requested by
+//   the lookup that precedes an open), but not when the .git directory is accessed or
+//   listed.
+// - objects/xx/yyyy: loose objects that are fetched on demand.
+
+const gitdirName = ".git"
+
+// gitcacheBudget is the total size of generated content kept in memory.
+const gitcacheBudget = 32 * 1024 * 1024
+
+type gitcache struct {
+	lock    sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type gitcacheEntry struct {
+	key     string
+	content []byte
+}
+
+func newGitcache() *gitcache {
+	return &gitcache{
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *gitcache) get(key string, fn func() ([]byte, error)) ([]byte, error) {
+	c.lock.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.lock.Unlock()
+		return elem.Value.(*gitcacheEntry).content, nil
+	}
+	c.lock.Unlock()
+
+	content, err := fn()
+	if nil != err {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.lru.PushFront(&gitcacheEntry{key, content})
+		c.size += len(content)
+		for elem := c.lru.Back(); nil != elem && c.lru.Front() != elem && gitcacheBudget < c.size; {
+			prev := elem.Prev()
+			entry := elem.Value.(*gitcacheEntry)
+			c.size -= len(entry.content)
+			c.lru.Remove(elem)
+			delete(c.entries, entry.key)
+			elem = prev
+		}
+	}
+	c.lock.Unlock()
+
+	return content, nil
+}
+
+func isHex(s string, n int) bool {
+	if n != len(s) {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return nil == err && strings.ToLower(s) == s
+}
+
+// gitdirIsDir determines if a path within the .git directory is a directory.
+func gitdirIsDir(path string) bool {
+	switch path {
+	case "", "objects", "objects/info", "objects/pack", "refs", "refs/heads", "refs/tags":
+		return true
+	}
+	return strings.HasPrefix(path, "objects/") && isHex(path[len("objects/"):], 2)
+}
+
+// gitdirList lists a directory within the .git directory.
+func gitdirList(path string) []string {
+	switch path {
+	case "":
+		return []string{"HEAD", "config", "index", "objects", "packed-refs", "refs"}
+	case "objects":
+		return []string{"info", "pack"}
+	case "refs":
+		return []string{"heads", "tags"}
+	}
+	return nil
+}
+
+// gitdirObject returns the object hash for a loose object path within the .git directory.
+func gitdirObject(path string) string {
+	if strings.HasPrefix(path, "objects/") && 48 == len(path) && '/' == path[10] {
+		hash := path[8:10] + path[11:]
+		if isHex(hash, 40) {
+			return hash
+		}
+	}
+	return ""
+}
+
+// gitdirOpen validates a path within the .git directory. The index is not built.
+func (fs *hubfs) gitdirOpen(obs *obstack) (errc int) {
+	if gitdirIsDir(obs.gitpath) || "index" == obs.gitpath {
+		return 0
+	}
+	_, errc = fs.gitdirContent(obs)
+	return
+}
+
+func (fs *hubfs) gitdirContent(obs *obstack) (content []byte, errc int) {
+	var err error
+	if hash := gitdirObject(obs.gitpath); "" != hash {
+		content, err = fs.gitcache.get(hash, func() ([]byte, error) {
+			return gitdirLooseObject(obs.repository, hash)
+		})
+		if nil != err {
+			// objects that cannot be obtained do not exist
+			return nil, -fuse.ENOENT
+		}
+		return content, 0
+	}
+
+	switch obs.gitpath {
+	case "HEAD", "config", "packed-refs", "index":
+	default:
+		return nil, -fuse.ENOENT
+	}
+
+	gitdir, err := obs.repository.GetGitDir(obs.ref)
+	if nil != err {
+		return nil, fuseErrc(err)
+	}
+
+	switch obs.gitpath {
+	case "HEAD":
+		if strings.HasPrefix(gitdir.Head, "refs/") {
+			content = []byte("ref: " + gitdir.Head + "\n")
+		} else {
+			content = []byte(gitdir.Head + "\n")
+		}
+	case "config":
+		filemode := "true"
+		if "windows" == runtime.GOOS {
+			filemode = "false"
+		}
+		content = []byte(fmt.Sprintf(""+
+			"[core]\n"+
+			"\trepositoryformatversion = 0\n"+
+			"\tfilemode = %s\n"+
+			"\tbare = false\n"+
+			"\tcheckStat = minimal\n"+
+			"\ttrustctime = false\n"+
+			"[remote \"origin\"]\n"+
+			"\turl = %s\n"+
+			"\tfetch = +refs/heads/*:refs/remotes/origin/*\n",
+			filemode, gitdir.Remote))
+	case "packed-refs":
+		names := make([]string, 0, len(gitdir.Refs))
+		for n := range gitdir.Refs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var buf bytes.Buffer
+		buf.WriteString("# pack-refs with: sorted \n")
+		for _, n := range names {
+			fmt.Fprintf(&buf, "%s %s\n", gitdir.Refs[n], n)
+		}
+		content = buf.Bytes()
+	case "index":
+		content, err = fs.gitcache.get("index:"+gitdir.Commit, func() ([]byte, error) {
+			return gitdirIndex(obs.repository, obs.ref)
+		})
+		if nil != err {
+			return nil, fuseErrc(err)
+		}
+	}
+
+	return content, 0
+}
+
+func (fs *hubfs) gitdirGetattr(obs *obstack, stat *fuse.Stat_t) (errc int) {
+	if gitdirIsDir(obs.gitpath) {
+		fuseStat(stat, fuse.S_IFDIR, 0, obs.ref.TreeTime())
+		return 0
+	}
+	var size int64
+	if reader, ok := obs.reader.(*bytes.Reader); ok {
+		size = reader.Size()
+	} else {
+		content, e := fs.gitdirContent(obs)
+		if 0 != e {
+			return e
+		}
+		size = int64(len(content))
+	}
+	fuseStat(stat, 0, size, time.Now())
+	stat.Mode &^= 0222
+	return 0
+}
+
+// gitdirListattr is like gitdirGetattr, but it does not build the index; the size of
+// the index is reported as 0 until it is looked up.
+func (fs *hubfs) gitdirListattr(obs *obstack, stat *fuse.Stat_t) (errc int) {
+	if "index" == obs.gitpath {
+		fuseStat(stat, 0, 0, time.Now())
+		stat.Mode &^= 0222
+		return 0
+	}
+	return fs.gitdirGetattr(obs, stat)
+}
+
+// gitdirLooseObject returns the content of a loose object file.
+func gitdirLooseObject(repository prov.Repository, hash string) ([]byte, error) {
+	kind, content, err := repository.GetObject(hash)
+	if nil != err {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writer := zlib.NewWriter(&buf)
+	fmt.Fprintf(writer, "%s %d\x00", kind, len(content))
+	writer.Write(content)
+	err = writer.Close()
+	if nil != err {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gitdirIndex returns the content of an index file (version 2) for the tree of a ref.
+// The stat data of the index entries matches that of the files in the ref directory.
+func gitdirIndex(repository prov.Repository, ref prov.Ref) ([]byte, error) {
+	type indexEntry struct {
+		path string
+		mode uint32
+		hash string
+		size int64
+	}
+	lst := []indexEntry{}
+
+	var walk func(prefix string, entry prov.TreeEntry) error
+	walk = func(prefix string, entry prov.TreeEntry) error {
+		tree, err := repository.GetTree(ref, entry)
+		if nil != err {
+			return err
+		}
+		for _, e := range tree {
+			path := prefix + e.Name()
+			mode := e.Mode()
+			switch mode & fuse.S_IFMT {
+			case fuse.S_IFDIR:
+				err = walk(path+"/", e)
+				if nil != err {
+					return err
+				}
+			case fuse.S_IFLNK:
+				lst = append(lst, indexEntry{path, 0120000, e.Hash(), int64(len(e.Target()))})
+			case 0160000 /* submodule */ :
+				lst = append(lst, indexEntry{path, 0160000, e.Hash(), 0})
+			default:
+				mode = 0100644
+				if 0 != e.Mode()&0111 {
+					mode = 0100755
+				}
+				lst = append(lst, indexEntry{path, mode, e.Hash(), e.Size()})
+			}
+		}
+		return nil
+	}
+	err := walk("", nil)
+	if nil != err {
+		return nil, err
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[i].path < lst[j].path })
+
+	mtime := ref.TreeTime()
+	sec, nsec := uint32(mtime.Unix()), uint32(mtime.Nanosecond())
+
+	var buf bytes.Buffer
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, []uint32{2, uint32(len(lst))})
+	for _, e := range lst {
+		hash, err := hex.DecodeString(e.hash)
+		if nil != err || 20 != len(hash) {
+			return nil, fmt.Errorf("invalid object hash %q", e.hash)
+		}
+		flags := len(e.path)
+		if 0xfff < flags {
+			flags = 0xfff
+		}
+		binary.Write(&buf, binary.BigEndian, []uint32{
+			sec, nsec, // ctime
+			sec, nsec, // mtime
+			0, 0, // dev, ino
+			e.mode,
+			0, 0, // uid, gid
+			uint32(e.size),
+		})
+		buf.Write(hash)
+		binary.Write(&buf, binary.BigEndian, uint16(flags))
+		buf.WriteString(e.path)
+		// entries are NUL padded to a multiple of 8 bytes
+		n := 62 + len(e.path)
+		buf.Write(make([]byte, (n+8)&^7-n))
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	return buf.Bytes(), nil
+}
@@ -14,6 +14,7 @@
 package hubfs
 
 import (
+	"bytes"
 	"io"
 	pathutil "path"
 	"path/filepath"
@@ -30,11 +31,12 @@ import (
 
 type hubfs struct {
 	fuse.FileSystemBase
-	client  prov.Client
-	prefix  string
-	lock    sync.RWMutex
-	fh      uint64
-	openmap map[uint64]*obstack
+	client   prov.Client
+	prefix   string
+	lock     sync.RWMutex
+	fh       uint64
+	openmap  map[uint64]*obstack
+	gitcache *gitcache
 }
 
 type obstack struct {
@@ -43,6 +45,8 @@ type obstack struct {
 	ref        prov.Ref
 	entry      prov.TreeEntry
 	reader     io.ReaderAt
+	isgit      bool
+	gitpath    string
 }
 
 type Config struct {
@@ -54,9 +58,10 @@ type Config struct {
 
 func new(c Config) fuse.FileSystemInterface {
 	return &hubfs{
-		client:  c.Client,
-		prefix:  c.Prefix,
-		openmap: make(map[uint64]*obstack),
+		client:   c.Client,
+		prefix:   c.Prefix,
+		openmap:  make(map[uint64]*obstack),
+		gitcache: newGitcache(),
 	}
 }
 
@@ -97,6 +102,19 @@ func (fs *hubfs) openex(path string, norm bool) (errc int, res *obstack, lst []s
 			if norm && nil == err {
 				lst[i] = obs.ref.Name()
 			}
+		case 3:
+			if gitdirName == c {
+				// the virtual .git directory; see gitdir.go
+				obs.isgit = true
+				obs.gitpath = strings.Join(lst[i+1:], "/")
+				if errc = fs.gitdirOpen(obs); 0 != errc {
+					fs.release(obs)
+					return
+				}
+				res = obs
+				return
+			}
+			fallthrough
 		default:
 			obs.entry, err = obs.repository.GetTreeEntry(obs.ref, obs.entry, c)
 			if norm && nil == err {
@@ -184,12 +202,25 @@ func (fs *hubfs) Getpath(path string, fh uint64) (errc int, normpath string) {
 func (fs *hubfs) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc, stat)
 
+	/* the content of an open .git file is not regenerated */
+	fs.lock.RLock()
+	if o, ok := fs.openmap[fh]; ok && o.isgit && nil != o.reader {
+		fs.lock.RUnlock()
+		errc = fs.gitdirGetattr(o, stat)
+		return
+	}
+	fs.lock.RUnlock()
+
 	errc, obs := fs.open(path)
 	if 0 != errc {
 		return
 	}
 
-	fs.getattr(obs, obs.entry, path, stat)
+	if obs.isgit {
+		errc = fs.gitdirGetattr(obs, stat)
+	} else {
+		fs.getattr(obs, obs.entry, path, stat)
+	}
 
 	fs.release(obs)
 
@@ -255,7 +286,18 @@ func (fs *hubfs) Readdir(path string,
 	fill(".", &stat, 0)
 	fill("..", &stat, 0)
 
-	if nil != obs.ref {
+	if obs.isgit {
+		for _, n := range gitdirList(obs.gitpath) {
+			obs := &obstack{repository: obs.repository, ref: obs.ref, isgit: true,
+				gitpath: pathutil.Join(obs.gitpath, n)}
+			if 0 != fs.gitdirListattr(obs, &stat) {
+				continue
+			}
+			if !fill(n, &stat, 0) {
+				break
+			}
+		}
+	} else if nil != obs.ref {
 		if lst, err := obs.repository.GetTree(obs.ref, obs.entry); nil == err {
 			for _, elm := range lst {
 				n := elm.Name()
@@ -321,6 +363,16 @@ func (fs *hubfs) Open(path string, flags int) (errc int, fh uint64) {
 		return
 	}
 
+	if obs.isgit {
+		content, e := fs.gitdirContent(obs)
+		if 0 != e {
+			fs.release(obs)
+			errc = e
+			return
+		}
+		obs.reader = bytes.NewReader(content)
+	}
+
 	fs.lock.Lock()
 	fh = fs.fh
 	fs.openmap[fh] = obs
@@ -399,6 +451,29 @@ func (fs *hubfs) Release(path string, fh uint64) (errc int) {
 	return
 }
 
+// Utimens on a repository directory (e.g. touch owner/repo) requests that its refs
+// are refreshed.
+func (fs *hubfs) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
+	defer trace(path, tmsp)(&errc)
+
+	errc, obs := fs.open(path)
+	if 0 != errc {
+		return
+	}
+
+	if nil != obs.repository && nil == obs.ref {
+		if err := obs.repository.RefreshRefs(); nil != err {
+			errc = fuseErrc(err)
+		}
+	} else {
+		errc = -fuse.ENOSYS
+	}
+
+	fs.release(obs)
+
+	return
+}
+
 func (self *hubfs) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
 	return port.Statfs(self.client.GetDirectory(), stat)
 }
@@ -126,7 +126,7 @@ func newOverlay(c Config) fuse.FileSystemInterface {
 			Caseins: caseins,
 		})
 
-		return newShardfs(topfs, prefix, obs, unfs)
+		return newShardfs(topfs, prefix, obs, caseins, unfs)
 	}
 
 	return overlayfs.New(overlayfs.Config{
@@ -0,0 +1,151 @@
+/*
+ * reset.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"fmt"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/winfsp/hubfs/fs/port"
+	"github.com/winfsp/hubfs/fs/ptfs"
+	"github.com/winfsp/hubfs/fs/unionfs"
+	"github.com/winfsp/hubfs/prov"
+)
+
+// resetName is the name of the virtual write-only file at the root of a ref directory
+// that requests the overlay of the ref to be reset. The file accepts a list of paths,
+// one per line; an empty list or the path "/" resets the entire ref.
+const resetName = ".reset"
+
+// ParseResetPaths parses a list of paths to reset, one per line. The paths are cleaned
+// and made absolute; a nil result means that the entire ref is to be reset.
+func ParseResetPaths(s string) (res []string) {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if "" == line {
+			continue
+		}
+		path := pathutil.Clean("/" + line)
+		if "/" == path {
+			return nil
+		}
+		res = append(res, path)
+	}
+	return
+}
+
+// ResetOverlay discards the changes that the overlay of a ref makes to the tree of the
+// ref. If paths is nil the upper layer of the overlay (including its path map) is
+// removed; otherwise only the listed paths are reset by removing them from the upper
+// layer and clearing the whiteouts and opaque directories recorded for them in the path
+// map. The overlay must not be in use.
+func ResetOverlay(repository prov.Repository, ref prov.Ref, caseins bool, paths []string) (
+	err error) {
+	root := OverlayDirectory(repository, ref)
+	if _, err = os.Stat(root); nil != err {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if nil == paths {
+		return os.RemoveAll(root)
+	}
+	errc, realroot := port.Realpath(root)
+	if 0 != errc {
+		return fmt.Errorf("%s: cannot resolve path (errc=%d)", root, errc)
+	}
+	root = realroot
+
+	if _, e := os.Stat(filepath.Join(root, pathmapName)); nil == e {
+		errc, pm := unionfs.OpenPathmap(ptfs.New(root), "/"+pathmapName, caseins)
+		if 0 != errc {
+			return fmt.Errorf("%s: cannot read path map (errc=%d)", root, errc)
+		}
+		defer pm.Close()
+
+		for _, path := range paths {
+			err = resetPathmap(pm, repository, ref, path)
+			if nil != err {
+				return
+			}
+		}
+		if n := pm.Write(true); 0 > n {
+			return fmt.Errorf("%s: cannot write path map (errc=%d)", root, n)
+		}
+	}
+
+	for _, path := range paths {
+		err = os.RemoveAll(filepath.Join(root, filepath.FromSlash(path)))
+		if nil != err {
+			return
+		}
+	}
+
+	return nil
+}
+
+// resetPathmap makes the tree of the ref visible again at a path by clearing the
+// whiteouts and opaque directories of the path and of everything below it.
+func resetPathmap(pm *unionfs.Pathmap, repository prov.Repository, ref prov.Ref, path string) (
+	err error) {
+	hidden := func(path string) bool {
+		v, ok := pm.TryGet(path)
+		return ok && (unionfs.WHITEOUT == v || unionfs.OPAQUE == v)
+	}
+
+	for dir := pathutil.Dir(path); "/" != dir; dir = pathutil.Dir(dir) {
+		if hidden(dir) {
+			return fmt.Errorf("%s: cannot reset path within deleted directory %s", path, dir)
+		}
+	}
+
+	var entry prov.TreeEntry
+	for _, name := range strings.Split(path[1:], "/") {
+		entry, err = repository.GetTreeEntry(ref, entry, name)
+		if prov.ErrNotFound == err {
+			/* not in the ref; nothing to make visible */
+			pm.SetIf(path, 1)
+			return nil
+		}
+		if nil != err {
+			return
+		}
+	}
+
+	var reset func(path string, entry prov.TreeEntry) error
+	reset = func(path string, entry prov.TreeEntry) error {
+		/* visibility index 1 is the lower (i.e. ref) file system */
+		pm.SetIf(path, 1)
+		if 0040000 != entry.Mode() {
+			return nil
+		}
+		lst, err := repository.GetTree(ref, entry)
+		if nil != err {
+			return err
+		}
+		for _, e := range lst {
+			err = reset(pathutil.Join(path, e.Name()), e)
+			if nil != err {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return reset(path, entry)
+}
@@ -14,35 +14,107 @@
 package hubfs
 
 import (
+	"strings"
 	"sync"
 
 	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/overlayfs"
+	"github.com/winfsp/hubfs/prov"
 )
 
 type shardfs struct {
 	fuse.FileSystemInterface
 	fuse.FileSystemGetpath
-	topfs    *hubfs
-	prefix   string
-	obs      *obstack
-	keeppath string
-	once     sync.Once
+	topfs      *hubfs
+	prefix     string
+	obs        *obstack
+	caseins    bool
+	keeppath   string
+	once       sync.Once
+	resetpath  string
+	resetmux   sync.Mutex
+	resetfh    uint64
+	resetmap   map[uint64][]byte
+	reset      bool
+	resetpaths []string
 }
 
-func newShardfs(topfs *hubfs, prefix string, obs *obstack, fs fuse.FileSystemInterface) fuse.FileSystemInterface {
-	return &shardfs{
+func newShardfs(topfs *hubfs, prefix string, obs *obstack, caseins bool,
+	fs fuse.FileSystemInterface) fuse.FileSystemInterface {
+	res := &shardfs{
 		FileSystemInterface: fs,
 		FileSystemGetpath:   fs.(fuse.FileSystemGetpath),
 		topfs:               topfs,
 		prefix:              prefix,
 		obs:                 obs,
-		keeppath:            "/.keep",
+		caseins:             caseins,
+		keeppath:            "/" + keepName,
+		resetfh:             ^uint64(1),
+		resetmap:            make(map[uint64][]byte),
 	}
+
+	/* the virtual reset file does not hide a file of the same name in the ref */
+	if _, err := obs.repository.GetTreeEntry(obs.ref, nil, resetName); prov.ErrNotFound == err {
+		res.resetpath = "/" + resetName
+	}
+
+	return res
+}
+
+// isGitPath determines if any of the paths is within the virtual .git directory (see
+// gitdir.go), which is read-only.
+func isGitPath(paths ...string) bool {
+	for _, path := range paths {
+		i := strings.IndexByte(path[1:], '/') + 1
+		if 0 == i {
+			i = len(path)
+		}
+		if strings.EqualFold("/"+gitdirName, path[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *shardfs) isResetPath(path string) bool {
+	if "" == fs.resetpath {
+		return false
+	}
+	if fs.caseins {
+		return strings.EqualFold(fs.resetpath, path)
+	}
+	return fs.resetpath == path
+}
+
+// openReset opens the virtual reset file. Reset file handles are allocated downwards
+// from the top of the handle space, so that they do not collide with unionfs handles.
+func (fs *shardfs) openReset() (fh uint64) {
+	fs.resetmux.Lock()
+	defer fs.resetmux.Unlock()
+	for {
+		fh = fs.resetfh
+		fs.resetfh--
+		if _, ok := fs.resetmap[fh]; !ok {
+			break
+		}
+	}
+	fs.resetmap[fh] = nil
+	return
 }
 
+func (fs *shardfs) isResetFh(fh uint64) bool {
+	fs.resetmux.Lock()
+	defer fs.resetmux.Unlock()
+	_, ok := fs.resetmap[fh]
+	return ok
+}
+
+// initonce creates the keep file when the overlay is first written. A new keep file
+// records the commit of the ref (see OverlayBase); an existing one is left as is.
 func (fs *shardfs) initonce() {
 	fs.once.Do(func() {
-		errc, fh := fs.FileSystemInterface.Create(fs.keeppath, fuse.O_CREAT|fuse.O_RDWR, 0644)
+		errc, fh := fs.FileSystemInterface.Create(fs.keeppath,
+			fuse.O_CREAT|fuse.O_EXCL|fuse.O_RDWR, 0644)
 		if -fuse.ENOSYS == errc {
 			errc = fs.FileSystemInterface.Mknod(fs.keeppath, 0644, 0)
 			if 0 == errc {
@@ -50,17 +122,48 @@ func (fs *shardfs) initonce() {
 			}
 		}
 		if 0 == errc {
+			if gitdir, err := fs.obs.repository.GetGitDir(fs.obs.ref); nil == err {
+				fs.FileSystemInterface.Write(fs.keeppath, []byte(gitdir.Commit+"\n"), 0, fh)
+			}
 			fs.FileSystemInterface.Release(fs.keeppath, fh)
 		}
 	})
 }
 
+// Expire reports whether a reset has been requested, in which case the shard is destroyed
+// as soon as it is no longer in use. The reset is performed by Destroy, which overlayfs
+// calls for an expired shard without holding its lock; accesses to the ref wait until
+// Destroy completes.
+func (fs *shardfs) Expire() bool {
+	fs.resetmux.Lock()
+	defer fs.resetmux.Unlock()
+	return fs.reset
+}
+
 func (fs *shardfs) Destroy() {
 	fs.FileSystemInterface.Destroy()
+	if fs.reset {
+		err := ResetOverlay(fs.obs.repository, fs.obs.ref, fs.caseins, fs.resetpaths)
+		if nil != err {
+			tracef("prefix=%q ResetOverlay = %v", fs.prefix, err)
+		}
+	}
 	fs.topfs.release(fs.obs)
 }
 
+func (fs *shardfs) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
+	if fs.isResetFh(fh) || fs.isResetPath(path) {
+		fuseStat(stat, fuse.S_IFREG, 0, fs.obs.ref.TreeTime())
+		stat.Mode = fuse.S_IFREG | 0222
+		return 0
+	}
+	return fs.FileSystemInterface.Getattr(path, stat, fh)
+}
+
 func (fs *shardfs) Mknod(path string, mode uint32, dev uint64) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Mknod(path, mode, dev)
 	if 0 == errc {
 		fs.initonce()
@@ -69,6 +172,9 @@ func (fs *shardfs) Mknod(path string, mode uint32, dev uint64) (errc int) {
 }
 
 func (fs *shardfs) Mkdir(path string, mode uint32) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Mkdir(path, mode)
 	if 0 == errc {
 		fs.initonce()
@@ -77,6 +183,9 @@ func (fs *shardfs) Mkdir(path string, mode uint32) (errc int) {
 }
 
 func (fs *shardfs) Unlink(path string) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Unlink(path)
 	if 0 == errc && fs.keeppath != path {
 		fs.initonce()
@@ -85,6 +194,9 @@ func (fs *shardfs) Unlink(path string) (errc int) {
 }
 
 func (fs *shardfs) Rmdir(path string) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Rmdir(path)
 	if 0 == errc {
 		fs.initonce()
@@ -93,6 +205,9 @@ func (fs *shardfs) Rmdir(path string) (errc int) {
 }
 
 func (fs *shardfs) Link(oldpath string, newpath string) (errc int) {
+	if isGitPath(oldpath, newpath) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Link(oldpath, newpath)
 	if 0 == errc {
 		fs.initonce()
@@ -101,6 +216,9 @@ func (fs *shardfs) Link(oldpath string, newpath string) (errc int) {
 }
 
 func (fs *shardfs) Symlink(target string, newpath string) (errc int) {
+	if isGitPath(newpath) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Symlink(target, newpath)
 	if 0 == errc {
 		fs.initonce()
@@ -109,6 +227,9 @@ func (fs *shardfs) Symlink(target string, newpath string) (errc int) {
 }
 
 func (fs *shardfs) Rename(oldpath string, newpath string) (errc int) {
+	if isGitPath(oldpath, newpath) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Rename(oldpath, newpath)
 	if 0 == errc {
 		fs.initonce()
@@ -117,6 +238,9 @@ func (fs *shardfs) Rename(oldpath string, newpath string) (errc int) {
 }
 
 func (fs *shardfs) Chmod(path string, mode uint32) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Chmod(path, mode)
 	if 0 == errc {
 		fs.initonce()
@@ -125,6 +249,9 @@ func (fs *shardfs) Chmod(path string, mode uint32) (errc int) {
 }
 
 func (fs *shardfs) Chown(path string, uid uint32, gid uint32) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Chown(path, uid, gid)
 	if 0 == errc {
 		fs.initonce()
@@ -133,6 +260,9 @@ func (fs *shardfs) Chown(path string, uid uint32, gid uint32) (errc int) {
 }
 
 func (fs *shardfs) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Utimens(path, tmsp)
 	if 0 == errc {
 		fs.initonce()
@@ -140,7 +270,26 @@ func (fs *shardfs) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
 	return
 }
 
+func (fs *shardfs) Open(path string, flags int) (errc int, fh uint64) {
+	if isGitPath(path) && fuse.O_RDONLY != flags&fuse.O_ACCMODE {
+		return -fuse.EROFS, ^uint64(0)
+	}
+	if fs.isResetPath(path) {
+		if fuse.O_RDONLY == flags&fuse.O_ACCMODE {
+			return -fuse.EACCES, ^uint64(0)
+		}
+		return 0, fs.openReset()
+	}
+	return fs.FileSystemInterface.Open(path, flags)
+}
+
 func (fs *shardfs) Create(path string, flags int, mode uint32) (errc int, fh uint64) {
+	if isGitPath(path) {
+		return -fuse.EROFS, ^uint64(0)
+	}
+	if fs.isResetPath(path) {
+		return 0, fs.openReset()
+	}
 	errc, fh = fs.FileSystemInterface.Create(path, flags, mode)
 	if 0 == errc {
 		fs.initonce()
@@ -149,6 +298,24 @@ func (fs *shardfs) Create(path string, flags int, mode uint32) (errc int, fh uin
 }
 
 func (fs *shardfs) Truncate(path string, size int64, fh uint64) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
+	if fs.isResetFh(fh) {
+		fs.resetmux.Lock()
+		buf := fs.resetmap[fh]
+		if int64(len(buf)) > size {
+			buf = buf[:size]
+		} else {
+			buf = append(buf, make([]byte, size-int64(len(buf)))...)
+		}
+		fs.resetmap[fh] = buf
+		fs.resetmux.Unlock()
+		return 0
+	}
+	if fs.isResetPath(path) {
+		return 0
+	}
 	errc = fs.FileSystemInterface.Truncate(path, size, fh)
 	if 0 == errc {
 		fs.initonce()
@@ -156,7 +323,25 @@ func (fs *shardfs) Truncate(path string, size int64, fh uint64) (errc int) {
 	return
 }
 
+func (fs *shardfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	if fs.isResetFh(fh) {
+		return 0
+	}
+	return fs.FileSystemInterface.Read(path, buff, ofst, fh)
+}
+
 func (fs *shardfs) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	if fs.isResetFh(fh) {
+		fs.resetmux.Lock()
+		buf := fs.resetmap[fh]
+		if end := ofst + int64(len(buff)); int64(len(buf)) < end {
+			buf = append(buf, make([]byte, end-int64(len(buf)))...)
+		}
+		copy(buf[ofst:], buff)
+		fs.resetmap[fh] = buf
+		fs.resetmux.Unlock()
+		return len(buff)
+	}
 	n = fs.FileSystemInterface.Write(path, buff, ofst, fh)
 	if 0 <= n {
 		fs.initonce()
@@ -164,7 +349,44 @@ func (fs *shardfs) Write(path string, buff []byte, ofst int64, fh uint64) (n int
 	return
 }
 
+func (fs *shardfs) Flush(path string, fh uint64) (errc int) {
+	if fs.isResetFh(fh) {
+		return 0
+	}
+	return fs.FileSystemInterface.Flush(path, fh)
+}
+
+func (fs *shardfs) Fsync(path string, datasync bool, fh uint64) (errc int) {
+	if fs.isResetFh(fh) {
+		return 0
+	}
+	return fs.FileSystemInterface.Fsync(path, datasync, fh)
+}
+
+func (fs *shardfs) Release(path string, fh uint64) (errc int) {
+	if fs.isResetFh(fh) {
+		/* the reset is performed when the shard is destroyed; see Destroy */
+		fs.resetmux.Lock()
+		paths := ParseResetPaths(string(fs.resetmap[fh]))
+		if !fs.reset {
+			fs.resetpaths = paths
+		} else if nil == paths || nil == fs.resetpaths {
+			fs.resetpaths = nil
+		} else {
+			fs.resetpaths = append(fs.resetpaths, paths...)
+		}
+		fs.reset = true
+		delete(fs.resetmap, fh)
+		fs.resetmux.Unlock()
+		return 0
+	}
+	return fs.FileSystemInterface.Release(path, fh)
+}
+
 func (fs *shardfs) Setxattr(path string, name string, value []byte, flags int) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Setxattr(path, name, value, flags)
 	if 0 == errc {
 		fs.initonce()
@@ -173,6 +395,9 @@ func (fs *shardfs) Setxattr(path string, name string, value []byte, flags int) (
 }
 
 func (fs *shardfs) Removexattr(path string, name string) (errc int) {
+	if isGitPath(path) {
+		return -fuse.EROFS
+	}
 	errc = fs.FileSystemInterface.Removexattr(path, name)
 	if 0 == errc {
 		fs.initonce()
@@ -200,3 +425,4 @@ var _ fuse.FileSystemGetpath = (*shardfs)(nil)
 var _ fuse.FileSystemChflags = (*shardfs)(nil)
 var _ fuse.FileSystemSetcrtime = (*shardfs)(nil)
 var _ fuse.FileSystemSetchgtime = (*shardfs)(nil)
+var _ overlayfs.FileSystemExpire = (*shardfs)(nil)
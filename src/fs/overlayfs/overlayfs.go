@@ -31,6 +31,7 @@ type filesystem struct {
 	ttl     time.Duration
 	fsmux   sync.Mutex
 	fsmap   map[string]*shardfs
+	busymap map[string]chan struct{}
 	nullfs  *shardfs
 }
 
@@ -42,6 +43,15 @@ type shardfs struct {
 	timer      *time.Timer
 }
 
+// FileSystemExpire is implemented by shard file systems that can request to be destroyed
+// as soon as they are no longer in use, regardless of the TimeToLive. An expired shard
+// file system is destroyed without holding the lock of the overlay file system, so that
+// a lengthy Destroy does not stall other shards; accesses to the same prefix wait until
+// Destroy completes.
+type FileSystemExpire interface {
+	Expire() bool
+}
+
 type Config struct {
 	Topfs      fuse.FileSystemInterface
 	Split      func(path string) (string, string)
@@ -58,6 +68,7 @@ func New(c Config) fuse.FileSystemInterface {
 		caseins: c.Caseins,
 		ttl:     c.TimeToLive,
 		fsmap:   make(map[string]*shardfs),
+		busymap: make(map[string]chan struct{}),
 		nullfs:  &shardfs{FileSystemInterface: nullfs.New(), rc: -1},
 	}
 }
@@ -75,6 +86,16 @@ func (fs *filesystem) acquirefs(path string, delta int) (dstfs *shardfs, remain
 	}
 
 	fs.fsmux.Lock()
+	for {
+		/* wait for an expired shard with the same prefix to be destroyed */
+		busy := fs.busymap[prefix]
+		if nil == busy {
+			break
+		}
+		fs.fsmux.Unlock()
+		<-busy
+		fs.fsmux.Lock()
+	}
 	dstfs = fs.fsmap[prefix]
 	if nil == dstfs {
 		if newfs := fs.newfs(csprefix); nil != newfs {
@@ -100,10 +121,22 @@ func (fs *filesystem) acquirefs(path string, delta int) (dstfs *shardfs, remain
 func (fs *filesystem) releasefs(dstfs *shardfs, delta int, errc *int) {
 	if (nil == errc || 0 != *errc) &&
 		!(0 > dstfs.rc) /* high bit of dstfs.rc is stable in presence of multiple threads */ {
+		var busy chan struct{}
 		fs.fsmux.Lock()
 		dstfs.rc += delta
 		if 0 == dstfs.rc {
-			if 0 == fs.ttl {
+			expire := false
+			if intf, ok := dstfs.FileSystemInterface.(FileSystemExpire); ok {
+				expire = intf.Expire()
+			}
+			if expire {
+				if nil != dstfs.timer {
+					dstfs.timer.Stop()
+				}
+				delete(fs.fsmap, dstfs.prefix)
+				busy = make(chan struct{})
+				fs.busymap[dstfs.prefix] = busy
+			} else if 0 == fs.ttl {
 				dstfs.Destroy()
 				delete(fs.fsmap, dstfs.prefix)
 			} else {
@@ -117,6 +150,14 @@ func (fs *filesystem) releasefs(dstfs *shardfs, delta int, errc *int) {
 			}
 		}
 		fs.fsmux.Unlock()
+
+		if nil != busy {
+			dstfs.Destroy()
+			fs.fsmux.Lock()
+			delete(fs.busymap, dstfs.prefix)
+			fs.fsmux.Unlock()
+			close(busy)
+		}
 	}
 }
 
@@ -135,6 +176,18 @@ func (fs *filesystem) Init() {
 
 func (fs *filesystem) Destroy() {
 	fs.fsmux.Lock()
+	for {
+		var busy chan struct{}
+		for _, busy = range fs.busymap {
+			break
+		}
+		if nil == busy {
+			break
+		}
+		fs.fsmux.Unlock()
+		<-busy
+		fs.fsmux.Lock()
+	}
 	for _, fs := range fs.fsmap {
 		fs.Destroy()
 	}
@@ -0,0 +1,114 @@
+/*
+ * attributes.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	pathutil "path"
+	"strings"
+)
+
+// LfsRule is a .gitattributes pattern that sets (Lfs true) or unsets the LFS filter.
+// Dir is the slash separated directory of the .gitattributes file ("" for the root).
+type LfsRule struct {
+	Dir     string
+	Pattern string
+	Lfs     bool
+}
+
+// DecodeLfsRules decodes the patterns of a .gitattributes file in directory dir that
+// specify the filter attribute. Macros, quoted patterns and directory patterns are
+// ignored.
+func DecodeLfsRules(dir string, content []byte) (res []LfsRule) {
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if 2 > len(fields) {
+			continue
+		}
+		pattern := fields[0]
+		if strings.HasPrefix(pattern, "#") ||
+			strings.HasPrefix(pattern, "[attr]") ||
+			strings.HasPrefix(pattern, "\"") ||
+			strings.HasPrefix(pattern, "!") ||
+			strings.HasSuffix(pattern, "/") {
+			continue
+		}
+		found, lfs := false, false
+		for _, attr := range fields[1:] {
+			switch {
+			case "filter=lfs" == attr:
+				found, lfs = true, true
+			case "filter" == attr || "-filter" == attr || "!filter" == attr ||
+				strings.HasPrefix(attr, "filter="):
+				found, lfs = true, false
+			}
+		}
+		if found {
+			res = append(res, LfsRule{Dir: dir, Pattern: pattern, Lfs: lfs})
+		}
+	}
+	return
+}
+
+// MatchLfsRules reports whether the file at the slash separated path uses the LFS
+// filter. Rules are ordered from the root to the deepest directory and within a file
+// from first to last; the last matching rule wins.
+func MatchLfsRules(rules []LfsRule, path string) bool {
+	for i := len(rules) - 1; 0 <= i; i-- {
+		if rules[i].match(path) {
+			return rules[i].Lfs
+		}
+	}
+	return false
+}
+
+func (rule *LfsRule) match(path string) bool {
+	if "" != rule.Dir {
+		if !strings.HasPrefix(path, rule.Dir+"/") {
+			return false
+		}
+		path = path[len(rule.Dir)+1:]
+	}
+
+	pattern := rule.Pattern
+	if !strings.Contains(pattern, "/") {
+		/* a pattern without a slash matches the file name at any depth */
+		ok, _ := pathutil.Match(pattern, pathutil.Base(path))
+		return ok
+	}
+
+	return matchSegments(
+		strings.Split(strings.TrimPrefix(pattern, "/"), "/"),
+		strings.Split(path, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for 0 < len(pattern) {
+		if "**" == pattern[0] {
+			for i := 0; len(name) >= i; i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if 0 == len(name) {
+			return false
+		}
+		if ok, _ := pathutil.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return 0 == len(name)
+}
@@ -0,0 +1,74 @@
+/*
+ * attributes_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"testing"
+)
+
+func TestDecodeLfsRules(t *testing.T) {
+	rules := DecodeLfsRules("dir", []byte(""+
+		"# comment filter=lfs\n"+
+		"[attr]binary -diff -merge -text\n"+
+		"*.bin filter=lfs diff=lfs merge=lfs -text\n"+
+		"*.txt text\n"+
+		"small.bin -filter\n"+
+		"docs/ filter=lfs\n"+
+		"*.dat filter=other\n"))
+	expect := []LfsRule{
+		{Dir: "dir", Pattern: "*.bin", Lfs: true},
+		{Dir: "dir", Pattern: "small.bin", Lfs: false},
+		{Dir: "dir", Pattern: "*.dat", Lfs: false},
+	}
+	if len(expect) != len(rules) {
+		t.Fatal(rules)
+	}
+	for i := range expect {
+		if expect[i] != rules[i] {
+			t.Error(i, rules[i])
+		}
+	}
+}
+
+func TestMatchLfsRules(t *testing.T) {
+	rules := append(
+		DecodeLfsRules("", []byte(""+
+			"*.bin filter=lfs\n"+
+			"/top.dat filter=lfs\n"+
+			"assets/**/*.png filter=lfs\n")),
+		DecodeLfsRules("sub", []byte(""+
+			"keep.bin -filter\n"+
+			"data/*.dat filter=lfs\n"))...)
+
+	expect := func(path string, lfs bool) {
+		if lfs != MatchLfsRules(rules, path) {
+			t.Errorf("path %q expect %v", path, lfs)
+		}
+	}
+
+	expect("a.bin", true)
+	expect("x/y/a.bin", true)
+	expect("a.txt", false)
+	expect("top.dat", true)
+	expect("x/top.dat", false)
+	expect("assets/a.png", true)
+	expect("assets/x/y/a.png", true)
+	expect("x/assets/a.png", false)
+	expect("sub/keep.bin", false)
+	expect("sub/x/keep.bin", false)
+	expect("keep.bin", true)
+	expect("sub/data/a.dat", true)
+	expect("sub/x/data/a.dat", false)
+	expect("data/a.dat", false)
+}
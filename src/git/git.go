@@ -15,7 +15,9 @@ package git
 
 import (
 	"context"
+	"errors"
 	"io"
+	"strings"
 	"time"
 
 	libtrace "github.com/billziss-gh/golib/trace"
@@ -27,6 +29,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/winfsp/hubfs/httputil"
 )
 
@@ -39,9 +42,20 @@ const (
 	TagObject    ObjectType = 4
 )
 
+// String returns the name of the object type as used in object headers (e.g. "blob").
+func (ot ObjectType) String() string {
+	return plumbing.ObjectType(ot).String()
+}
+
+var ErrNotSupported = errors.New("not supported")
+
 type Repository struct {
-	session transport.UploadPackSession
-	advrefs *packp.AdvRefs
+	session  transport.UploadPackSession
+	advrefs  *packp.AdvRefs
+	v2       *v2Client
+	client   transport.Transport
+	endpoint *transport.Endpoint
+	auth     transport.AuthMethod
 }
 
 type Signature struct {
@@ -73,6 +87,10 @@ func OpenRepository(remote string, username string, password string) (res *Repos
 		return nil, err
 	}
 
+	if "ssh" == endpoint.Protocol {
+		return OpenSshRepository(remote, "")
+	}
+
 	var auth transport.AuthMethod
 	if "" != username || "" != password {
 		auth = &http.BasicAuth{
@@ -81,29 +99,109 @@ func OpenRepository(remote string, username string, password string) (res *Repos
 		}
 	}
 
-	client := http.NewClient(httputil.DefaultClient)
-	session, err := client.NewUploadPackSession(endpoint, auth)
+	v2, advrefs, err := openV2(httputil.DefaultClient, endpoint, username, password)
 	if nil != err {
 		return nil, err
 	}
+	if nil != v2 {
+		return &Repository{
+			v2:       v2,
+			client:   http.NewClient(httputil.DefaultClient),
+			endpoint: endpoint,
+			auth:     auth,
+		}, nil
+	}
+
+	return openRepository(http.NewClient(httputil.DefaultClient), endpoint, auth, advrefs)
+}
 
-	advrefs, err := session.AdvertisedReferences()
+// OpenSshRepository opens a repository over SSH. The remote may be an ssh:// URL or use
+// the scp-like syntax [user@]host:path. If keyfile is empty the keys in ssh-agent are
+// used. Host keys are verified against the known_hosts files.
+func OpenSshRepository(remote string, keyfile string) (res *Repository, err error) {
+	endpoint, err := transport.NewEndpoint(remote)
+	if nil != err {
+		return nil, err
+	}
+	if "ssh" != endpoint.Protocol {
+		return nil, errors.New("not an ssh remote: " + remote)
+	}
+
+	username := endpoint.User
+	if "" == username {
+		username = ssh.DefaultUsername
+	}
+
+	var auth transport.AuthMethod
+	if "" != keyfile {
+		auth, err = ssh.NewPublicKeysFromFile(username, keyfile, "")
+	} else {
+		auth, err = ssh.NewSSHAgentAuth(username)
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	return openRepository(ssh.DefaultClient, endpoint, auth, nil)
+}
+
+// IsSshRemote determines if a remote uses the SSH transport.
+func IsSshRemote(remote string) bool {
+	endpoint, err := transport.NewEndpoint(remote)
+	return nil == err && "ssh" == endpoint.Protocol
+}
+
+func openRepository(client transport.Transport, endpoint *transport.Endpoint,
+	auth transport.AuthMethod, advrefs *packp.AdvRefs) (res *Repository, err error) {
+	session, err := client.NewUploadPackSession(endpoint, auth)
 	if nil != err {
-		session.Close()
 		return nil, err
 	}
 
+	// the reference advertisement may already be known (see openV2)
+	if nil == advrefs {
+		advrefs, err = session.AdvertisedReferences()
+		if nil != err {
+			session.Close()
+			return nil, err
+		}
+	}
+
+	// a session of a non-HTTP transport (e.g. SSH) ends with its first pack response;
+	// such sessions are opened for each fetch (see fetchPack)
+	if "http" != endpoint.Protocol && "https" != endpoint.Protocol {
+		session.Close()
+		session = nil
+	}
+
 	return &Repository{
-		session: session,
-		advrefs: advrefs,
+		session:  session,
+		advrefs:  advrefs,
+		client:   client,
+		endpoint: endpoint,
+		auth:     auth,
 	}, nil
 }
 
 func (repository *Repository) Close() (err error) {
+	if nil == repository.session {
+		return nil
+	}
 	return repository.session.Close()
 }
 
 func (repository *Repository) GetRefs() (res map[string]string, err error) {
+	return repository.ListRefs(nil)
+}
+
+// ListRefs returns the refs whose names start with one of the specified prefixes. If
+// there are no prefixes all refs are returned. With protocol version 2 the filtering
+// is performed by the server.
+func (repository *Repository) ListRefs(prefixes []string) (res map[string]string, err error) {
+	if nil != repository.v2 {
+		return repository.v2.listRefs(prefixes)
+	}
+
 	stg, err := repository.advrefs.AllReferences()
 	if nil != err {
 		return nil, err
@@ -111,7 +209,49 @@ func (repository *Repository) GetRefs() (res map[string]string, err error) {
 
 	res = make(map[string]string, len(stg))
 	for n, r := range stg {
-		res[string(n)] = r.Hash().String()
+		if hasRefPrefix(string(n), prefixes) {
+			res[string(n)] = r.Hash().String()
+		}
+	}
+
+	return res, nil
+}
+
+func hasRefPrefix(name string, prefixes []string) bool {
+	if 0 == len(prefixes) {
+		return true
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetObjectSizes returns the sizes of the specified objects without fetching their
+// content. It returns ErrNotSupported if the server cannot report object sizes.
+func (repository *Repository) GetObjectSizes(wants []string) (res map[string]int64, err error) {
+	if nil == repository.v2 {
+		return nil, ErrNotSupported
+	}
+	if _, ok := repository.v2.caps["object-info"]; !ok {
+		return nil, ErrNotSupported
+	}
+
+	res = make(map[string]int64, len(wants))
+	for i, j := 0, 0; len(wants) > i; i = j {
+		j = i + 1024
+		if len(wants) < j {
+			j = len(wants)
+		}
+		m, err := repository.v2.objectInfo(wants[i:j])
+		if nil != err {
+			return nil, err
+		}
+		for h, n := range m {
+			res[h] = n
+		}
 	}
 
 	return res, nil
@@ -187,8 +327,11 @@ func (obs *observer) OnFooter(h plumbing.Hash) error {
 	return nil
 }
 
-func (repository *Repository) fetchObjects(wants []string,
-	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
+func (repository *Repository) fetchPack(wants []string, fn func(reader io.Reader) error) (err error) {
+	if nil != repository.v2 {
+		return repository.v2.fetchPack(wants, fn)
+	}
+
 	defer trace(len(wants))(&err)
 
 	req := packp.NewUploadPackRequestFromCapabilities(repository.advrefs.Capabilities)
@@ -209,7 +352,16 @@ func (repository *Repository) fetchObjects(wants []string,
 		req.Wants[i] = plumbing.NewHash(w)
 	}
 
-	rsp, err := repository.session.UploadPack(context.Background(), req)
+	session := repository.session
+	if nil == session {
+		session, err = repository.client.NewUploadPackSession(repository.endpoint, repository.auth)
+		if nil != err {
+			return err
+		}
+		defer session.Close()
+	}
+
+	rsp, err := session.UploadPack(context.Background(), req)
 	if nil != err {
 		return err
 	}
@@ -225,6 +377,11 @@ func (repository *Repository) fetchObjects(wants []string,
 		reader = rsp
 	}
 
+	return fn(reader)
+}
+
+func parsePackfile(reader io.Reader,
+	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
 	scn := packfile.NewScanner(reader)
 	stg := storemap{}
 	obs := &observer{fn: fn}
@@ -241,6 +398,8 @@ func (repository *Repository) fetchObjects(wants []string,
 	return nil
 }
 
+// FetchObjects fetches objects and passes their content to fn. Object content is held
+// in memory; use FetchObjectFiles for objects that may be large.
 func (repository *Repository) FetchObjects(wants []string,
 	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
 
@@ -249,7 +408,31 @@ func (repository *Repository) FetchObjects(wants []string,
 		if len(wants) < j {
 			j = len(wants)
 		}
-		err = repository.fetchObjects(wants[i:j], fn)
+		err = repository.fetchPack(wants[i:j], func(reader io.Reader) error {
+			return parsePackfile(reader, fn)
+		})
+		if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FetchObjectFiles fetches objects and stores their content in files under dir (see
+// ObjectFilePath). Object content is streamed to disk so that memory use is bounded
+// regardless of object size.
+func (repository *Repository) FetchObjectFiles(wants []string, dir string,
+	fn func(hash string, ot ObjectType) error) (err error) {
+
+	for i, j := 0, 0; len(wants) > i; i = j {
+		j = i + 256
+		if len(wants) < j {
+			j = len(wants)
+		}
+		err = repository.fetchPack(wants[i:j], func(reader io.Reader) error {
+			return unpackObjectFiles(reader, dir, fn)
+		})
 		if nil != err {
 			return err
 		}
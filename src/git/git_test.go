@@ -14,11 +14,18 @@
 package git
 
 import (
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/billziss-gh/golib/keyring"
 	libtrace "github.com/billziss-gh/golib/trace"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
 )
 
 const remote = "https://github.com/winfsp/hubfs"
@@ -160,6 +167,75 @@ func TestFetchObjects(t *testing.T) {
 	}
 }
 
+func TestFetchObjectsSession(t *testing.T) {
+	if _, err := exec.LookPath("git"); nil != err {
+		t.Skip(err)
+	}
+
+	dir, err := ioutil.TempDir("", "git_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = gogit.PlainInit(dir, true)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "config"),
+		[]byte("[core]\n\tbare = true\n[uploadpack]\n\tallowAnySHA1InWant = true\n"), 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	local, err := OpenLocalRepository(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	objects := map[string]*Object{}
+	blob, obj := NewObject(BlobObject, []byte("hello\n"))
+	objects[blob] = obj
+	content, _ := EncodeTree([]*TreeEntry{{Name: "file", Mode: 0100644, Hash: blob}})
+	tree, obj := NewObject(TreeObject, content)
+	objects[tree] = obj
+	sig := Signature{Name: "test", Email: "test@example.com", Time: time.Now()}
+	content, _ = EncodeCommit(tree, nil, sig, sig, "message\n")
+	commit, obj := NewObject(CommitObject, content)
+	objects[commit] = obj
+	err = local.Push("refs/heads/master", "", commit, objects)
+	local.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// upload-pack sessions of non-HTTP transports (such as SSH) end with the first
+	// pack response; every fetch must work
+	endpoint, err := transport.NewEndpoint("file://" + filepath.ToSlash(dir))
+	if nil != err {
+		t.Fatal(err)
+	}
+	repository, err := openRepository(file.DefaultClient, endpoint, nil, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	for _, want := range []string{commit, tree, blob} {
+		found := false
+		err = repository.FetchObjects([]string{want},
+			func(hash string, ot ObjectType, content []byte) error {
+				found = found || want == hash
+				return nil
+			})
+		if nil != err {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Error(want)
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	libtrace.Verbose = true
 	libtrace.Pattern = "github.com/winfsp/hubfs/*"
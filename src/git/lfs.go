@@ -0,0 +1,278 @@
+/*
+ * lfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/winfsp/hubfs/httputil"
+)
+
+// LfsPointerMaxSize is the maximum size of a Git LFS pointer file.
+const LfsPointerMaxSize = 1024
+
+const lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+type LfsPointer struct {
+	Oid  string
+	Size int64
+}
+
+// DecodeLfsPointer decodes the content of a Git LFS pointer file.
+func DecodeLfsPointer(content []byte) (res *LfsPointer, ok bool) {
+	if LfsPointerMaxSize < len(content) || !bytes.HasPrefix(content, []byte(lfsPointerVersion+"\n")) {
+		return nil, false
+	}
+
+	res = &LfsPointer{Size: -1}
+	for _, line := range strings.Split(string(content), "\n") {
+		switch {
+		case strings.HasPrefix(line, "oid sha256:"):
+			oid := line[len("oid sha256:"):]
+			if _, err := hex.DecodeString(oid); nil != err || 64 != len(oid) {
+				return nil, false
+			}
+			res.Oid = oid
+		case strings.HasPrefix(line, "size "):
+			size, err := strconv.ParseInt(line[len("size "):], 10, 64)
+			if nil != err || 0 > size {
+				return nil, false
+			}
+			res.Size = size
+		}
+	}
+	if "" == res.Oid || 0 > res.Size {
+		return nil, false
+	}
+
+	return res, true
+}
+
+// LfsObjectPath returns the path of the file that stores the content of an LFS object
+// under dir. It uses the same layout as git-lfs.
+func LfsObjectPath(dir string, oid string) string {
+	if 4 < len(oid) {
+		return filepath.Join(dir, oid[:2], oid[2:4], oid)
+	}
+	return ""
+}
+
+// VerifyLfsObject verifies that the content of an LFS object matches its oid.
+func VerifyLfsObject(reader io.ReaderAt, size int64, oid string) bool {
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, io.NewSectionReader(reader, 0, size))
+	return nil == err && size == n && hex.EncodeToString(hasher.Sum(nil)) == oid
+}
+
+// lfsURI returns the LFS server URI for a remote. SSH remotes use the HTTPS URI of
+// the same host and path; git-lfs-authenticate is not supported, so this only works
+// if the HTTPS endpoint accepts anonymous access or the configured credentials.
+func lfsURI(remote string) (string, error) {
+	endpoint, err := transport.NewEndpoint(remote)
+	if nil != err {
+		return "", err
+	}
+
+	scheme := endpoint.Protocol
+	host := endpoint.Host
+	switch scheme {
+	case "https", "http":
+		if 0 != endpoint.Port {
+			host = fmt.Sprintf("%s:%d", host, endpoint.Port)
+		}
+	case "ssh":
+		scheme = "https"
+	default:
+		return "", errors.New("LFS not supported for remote: " + remote)
+	}
+
+	path := "/" + strings.TrimPrefix(endpoint.Path, "/")
+	if !strings.HasSuffix(path, ".git") {
+		path += ".git"
+	}
+
+	return (&url.URL{Scheme: scheme, Host: host, Path: path + "/info/lfs"}).String(), nil
+}
+
+func lfsBatch(uri string, username string, password string, ptr *LfsPointer) (
+	href string, header map[string]string, err error) {
+	var request struct {
+		Operation string   `json:"operation"`
+		Transfers []string `json:"transfers"`
+		Objects   []struct {
+			Oid  string `json:"oid"`
+			Size int64  `json:"size"`
+		} `json:"objects"`
+	}
+	request.Operation = "download"
+	request.Transfers = []string{"basic"}
+	request.Objects = append(request.Objects, struct {
+		Oid  string `json:"oid"`
+		Size int64  `json:"size"`
+	}{ptr.Oid, ptr.Size})
+
+	var body bytes.Buffer
+	err = json.NewEncoder(&body).Encode(&request)
+	if nil != err {
+		return
+	}
+
+	req, err := http.NewRequest("POST", uri+"/objects/batch", &body)
+	if nil != err {
+		return
+	}
+	req.Header.Set("Accept", "application/vnd.git-lfs+json")
+	req.Header.Set("Content-Type", "application/vnd.git-lfs+json")
+	if "" != username || "" != password {
+		req.SetBasicAuth(username, password)
+	}
+
+	rsp, err := httputil.DefaultClient.Do(req)
+	if nil != err {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if 400 <= rsp.StatusCode {
+		return "", nil, httpError(rsp)
+	}
+
+	var content struct {
+		Objects []struct {
+			Oid     string `json:"oid"`
+			Actions struct {
+				Download struct {
+					Href   string            `json:"href"`
+					Header map[string]string `json:"header"`
+				} `json:"download"`
+			} `json:"actions"`
+			Error *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"objects"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return
+	}
+
+	for _, obj := range content.Objects {
+		if obj.Oid != ptr.Oid {
+			continue
+		}
+		if nil != obj.Error {
+			return "", nil, errors.New(fmt.Sprintf("LFS %d: %s", obj.Error.Code, obj.Error.Message))
+		}
+		if "" == obj.Actions.Download.Href {
+			break
+		}
+		return obj.Actions.Download.Href, obj.Actions.Download.Header, nil
+	}
+
+	return "", nil, errors.New("LFS object not available: " + ptr.Oid)
+}
+
+// FetchLfsObject downloads an LFS object using the batch API of the LFS server of the
+// remote and stores it under dir (see LfsObjectPath). The object content is streamed
+// to disk and verified against the pointer.
+func FetchLfsObject(remote string, username string, password string, ptr *LfsPointer,
+	dir string) (err error) {
+	defer trace(remote, ptr.Oid)(&err)
+
+	uri, err := lfsURI(remote)
+	if nil != err {
+		return
+	}
+
+	href, header, err := lfsBatch(uri, username, password, ptr)
+	if nil != err {
+		return
+	}
+
+	req, err := http.NewRequest("GET", href, nil)
+	if nil != err {
+		return
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	rsp, err := httputil.DefaultClient.Do(req)
+	if nil != err {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if 400 <= rsp.StatusCode {
+		return httpError(rsp)
+	}
+
+	p := LfsObjectPath(dir, ptr.Oid)
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if nil != err {
+		return
+	}
+	file, err := ioutil.TempFile(filepath.Dir(p), ".tmp")
+	if nil != err {
+		return
+	}
+	tmp := file.Name()
+	defer func() {
+		if nil != err {
+			os.Remove(tmp)
+		}
+	}()
+
+	hasher := sha256.New()
+	writer := bufio.NewWriterSize(io.MultiWriter(file, hasher), 64*1024)
+	n, err := io.Copy(writer, rsp.Body)
+	if nil == err {
+		err = writer.Flush()
+	}
+	if e := file.Close(); nil == err {
+		err = e
+	}
+	if nil != err {
+		return
+	}
+
+	if n != ptr.Size || hex.EncodeToString(hasher.Sum(nil)) != ptr.Oid {
+		return errors.New("LFS object content does not match pointer: " + ptr.Oid)
+	}
+
+	err = os.Rename(tmp, p)
+	if nil != err {
+		if _, e := os.Stat(p); nil == e {
+			os.Remove(tmp)
+			err = nil
+		}
+	}
+	return
+}
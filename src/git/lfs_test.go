@@ -0,0 +1,135 @@
+/*
+ * lfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+const lfsContent = "large file content\n"
+
+func lfsTestPointer() (string, *LfsPointer) {
+	sum := sha256.Sum256([]byte(lfsContent))
+	oid := hex.EncodeToString(sum[:])
+	text := fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, oid, len(lfsContent))
+	return text, &LfsPointer{Oid: oid, Size: int64(len(lfsContent))}
+}
+
+func TestDecodeLfsPointer(t *testing.T) {
+	text, ptr := lfsTestPointer()
+
+	p, ok := DecodeLfsPointer([]byte(text))
+	if !ok || *ptr != *p {
+		t.Error()
+	}
+
+	_, ok = DecodeLfsPointer([]byte(lfsContent))
+	if ok {
+		t.Error()
+	}
+
+	_, ok = DecodeLfsPointer([]byte(lfsPointerVersion + "\noid sha256:1234\nsize 10\n"))
+	if ok {
+		t.Error()
+	}
+}
+
+func TestLfsURI(t *testing.T) {
+	expect := func(remote string, euri string) {
+		uri, err := lfsURI(remote)
+		if nil != err || euri != uri {
+			t.Errorf("remote %q expect %q got (%q, %v)", remote, euri, uri, err)
+		}
+	}
+
+	expect("https://example.com/owner/repo", "https://example.com/owner/repo.git/info/lfs")
+	expect("https://example.com/owner/repo.git", "https://example.com/owner/repo.git/info/lfs")
+	expect("http://example.com:8080/owner/repo", "http://example.com:8080/owner/repo.git/info/lfs")
+	expect("git@example.com:owner/repo.git", "https://example.com/owner/repo.git/info/lfs")
+}
+
+func TestFetchLfsObject(t *testing.T) {
+	_, ptr := lfsTestPointer()
+
+	var server *httptest.Server
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/owner/repo.git/info/lfs/objects/batch":
+			if u, p, ok := req.BasicAuth(); !ok || "user" != u || "pass" != p {
+				w.WriteHeader(401)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"objects": []interface{}{
+					map[string]interface{}{
+						"oid":  ptr.Oid,
+						"size": ptr.Size,
+						"actions": map[string]interface{}{
+							"download": map[string]interface{}{
+								"href":   server.URL + "/content",
+								"header": map[string]string{"X-Test": "1"},
+							},
+						},
+					},
+				},
+			})
+		case "/content":
+			if "1" != req.Header.Get("X-Test") {
+				w.WriteHeader(403)
+				return
+			}
+			w.Write([]byte(lfsContent))
+		default:
+			w.WriteHeader(404)
+		}
+	}
+	server = httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "lfs_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = FetchLfsObject(server.URL+"/owner/repo", "user", "pass", ptr, dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(LfsObjectPath(dir, ptr.Oid))
+	if nil != err || lfsContent != string(content) {
+		t.Error(err)
+	}
+
+	err = FetchLfsObject(server.URL+"/owner/repo", "user", "wrong", ptr, dir)
+	if nil == err {
+		t.Error()
+	}
+
+	bad := *ptr
+	bad.Size++
+	err = FetchLfsObject(server.URL+"/owner/repo", "user", "pass", &bad, dir)
+	if nil == err {
+		t.Error()
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * local.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// LocalRepository is a git repository on local disk. Objects are read directly from
+// the repository's loose object files and pack files.
+type LocalRepository struct {
+	storage *filesystem.Storage
+}
+
+// IsLocalRepository determines if path is a bare repository or a repository with a
+// .git subdirectory and returns the path of the git directory.
+func IsLocalRepository(path string) (string, bool) {
+	isgitdir := func(path string) bool {
+		if info, err := os.Stat(filepath.Join(path, "HEAD")); nil != err || info.IsDir() {
+			return false
+		}
+		if info, err := os.Stat(filepath.Join(path, "objects")); nil != err || !info.IsDir() {
+			return false
+		}
+		return true
+	}
+	if isgitdir(path) {
+		return path, true
+	}
+	if p := filepath.Join(path, ".git"); isgitdir(p) {
+		return p, true
+	}
+	return "", false
+}
+
+func OpenLocalRepository(path string) (res *LocalRepository, err error) {
+	gitdir, ok := IsLocalRepository(path)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	storage := filesystem.NewStorage(osfs.New(gitdir), cache.NewObjectLRUDefault())
+
+	return &LocalRepository{
+		storage: storage,
+	}, nil
+}
+
+func (repository *LocalRepository) Close() (err error) {
+	return repository.storage.Close()
+}
+
+func (repository *LocalRepository) GetRefs() (res map[string]string, err error) {
+	return repository.ListRefs(nil)
+}
+
+func (repository *LocalRepository) ListRefs(prefixes []string) (res map[string]string, err error) {
+	iter, err := repository.storage.IterReferences()
+	if nil != err {
+		return nil, err
+	}
+	defer iter.Close()
+
+	res = make(map[string]string)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if plumbing.HashReference == ref.Type() && hasRefPrefix(ref.Name().String(), prefixes) {
+			res[ref.Name().String()] = ref.Hash().String()
+		}
+		return nil
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (repository *LocalRepository) GetObjectSizes(wants []string) (res map[string]int64, err error) {
+	res = make(map[string]int64, len(wants))
+	for _, w := range wants {
+		size, err := repository.storage.EncodedObjectSize(plumbing.NewHash(w))
+		if nil != err {
+			continue
+		}
+		res[w] = size
+	}
+
+	return res, nil
+}
+
+func (repository *LocalRepository) FetchObjects(wants []string,
+	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
+	defer trace(len(wants))(&err)
+
+	for _, w := range wants {
+		obj, err := repository.storage.EncodedObject(plumbing.AnyObject, plumbing.NewHash(w))
+		if nil != err {
+			return err
+		}
+
+		reader, err := obj.Reader()
+		if nil != err {
+			return err
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if nil != err {
+			return err
+		}
+
+		err = fn(obj.Hash().String(), ObjectType(obj.Type()), content)
+		if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repository *LocalRepository) FetchObjectFiles(wants []string, dir string,
+	fn func(hash string, ot ObjectType) error) (err error) {
+	defer trace(len(wants))(&err)
+
+	for _, w := range wants {
+		obj, err := repository.storage.EncodedObject(plumbing.AnyObject, plumbing.NewHash(w))
+		if nil != err {
+			return err
+		}
+
+		reader, err := obj.Reader()
+		if nil != err {
+			return err
+		}
+		hash, err := createObjectFile(dir, obj.Type(), obj.Size(), func(w io.Writer) error {
+			_, err := io.Copy(w, reader)
+			return err
+		})
+		reader.Close()
+		if nil != err {
+			return err
+		}
+
+		err = fn(hash, ObjectType(obj.Type()))
+		if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Push stores objects in the repository and updates a ref from oldhash (empty for a new
+// ref) to newhash. The update fails if the ref no longer has the value oldhash.
+func (repository *LocalRepository) Push(refname string, oldhash string, newhash string,
+	objects map[string]*Object) (err error) {
+	defer trace(refname, oldhash, newhash, len(objects))(&err)
+
+	name := plumbing.ReferenceName(refname)
+	ref, err := repository.storage.Reference(name)
+	if nil == err {
+		if ref.Hash().String() != oldhash {
+			return ErrRefChanged
+		}
+	} else if plumbing.ErrReferenceNotFound != err {
+		return err
+	} else if "" != oldhash {
+		return ErrRefChanged
+	}
+
+	stg, _ := newStoremap(objects)
+	for _, obj := range stg {
+		_, err = repository.storage.SetEncodedObject(obj)
+		if nil != err {
+			return err
+		}
+	}
+
+	return repository.storage.CheckAndSetReference(
+		plumbing.NewHashReference(name, plumbing.NewHash(newhash)), ref)
+}
@@ -0,0 +1,458 @@
+/*
+ * objfile.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Object files are stored compressed in chunks, so that random access only needs to
+// decompress the chunks that are read. The format is:
+//
+//     magic | chunk... | offset... | size | type | magic
+//
+// Each chunk holds objectChunkSize bytes of content (the last one possibly fewer)
+// compressed with deflate. There are n+1 offsets (uint64), where n is the number of
+// chunks: the file offset of each chunk followed by the file offset of the first
+// offset. The size (uint64) is the size of the content and the type (uint64) is the
+// object type.
+//
+// Version 1 files (magic HUBFSOZ1) have no type field and are still read. Object files
+// of earlier releases stored uncompressed content; these are verified against the
+// hash in their path and rewritten in the current format when opened. LFS objects are
+// stored uncompressed and are opened with OpenRawObjectFile.
+
+const objectChunkSize = 64 * 1024
+
+var (
+	objectFileMagic  = []byte("HUBFSOZ2")
+	objectFileMagic1 = []byte("HUBFSOZ1")
+)
+
+var errInvalidObjectFile = errors.New("invalid object file")
+
+// objectFileWriter writes compressed object file content.
+type objectFileWriter struct {
+	writer  io.Writer
+	flater  *flate.Writer
+	buf     []byte
+	offset  int64
+	offsets []int64
+	size    int64
+	otype   ObjectType
+	err     error
+}
+
+// NewObjectFileWriter returns a writer that compresses content of an object of type ot
+// written to it in the object file format. The writer must be closed to complete the
+// object file.
+func NewObjectFileWriter(w io.Writer, ot ObjectType) io.WriteCloser {
+	ow := &objectFileWriter{
+		writer: w,
+		buf:    make([]byte, 0, objectChunkSize),
+		otype:  ot,
+	}
+	ow.write(objectFileMagic)
+	return ow
+}
+
+func (ow *objectFileWriter) write(p []byte) {
+	if nil != ow.err {
+		return
+	}
+	n, err := ow.writer.Write(p)
+	ow.offset += int64(n)
+	ow.err = err
+}
+
+func (ow *objectFileWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for 0 < len(p) && nil == ow.err {
+		m := copy(ow.buf[len(ow.buf):cap(ow.buf)], p)
+		ow.buf = ow.buf[:len(ow.buf)+m]
+		p = p[m:]
+		if len(ow.buf) == cap(ow.buf) {
+			ow.flush()
+		}
+	}
+	if nil != ow.err {
+		return 0, ow.err
+	}
+	ow.size += int64(n)
+	return n, nil
+}
+
+func (ow *objectFileWriter) flush() {
+	if 0 == len(ow.buf) || nil != ow.err {
+		return
+	}
+	ow.offsets = append(ow.offsets, ow.offset)
+	var chunk bytes.Buffer
+	if nil == ow.flater {
+		ow.flater, ow.err = flate.NewWriter(&chunk, flate.DefaultCompression)
+	} else {
+		ow.flater.Reset(&chunk)
+	}
+	if nil == ow.err {
+		_, ow.err = ow.flater.Write(ow.buf)
+	}
+	if nil == ow.err {
+		ow.err = ow.flater.Close()
+	}
+	ow.write(chunk.Bytes())
+	ow.buf = ow.buf[:0]
+}
+
+func (ow *objectFileWriter) Close() error {
+	ow.flush()
+	tail := make([]byte, 8*(len(ow.offsets)+3)+len(objectFileMagic))
+	i := 0
+	for _, o := range append(ow.offsets, ow.offset) {
+		binary.LittleEndian.PutUint64(tail[i:], uint64(o))
+		i += 8
+	}
+	binary.LittleEndian.PutUint64(tail[i:], uint64(ow.size))
+	binary.LittleEndian.PutUint64(tail[i+8:], uint64(ow.otype))
+	copy(tail[i+16:], objectFileMagic)
+	ow.write(tail)
+	return ow.err
+}
+
+// ObjectFile provides sequential and random access to the content of an object file.
+type ObjectFile struct {
+	file     *os.File
+	size     int64
+	disksize int64
+	offsets  []int64
+	otype    ObjectType
+	lock     sync.Mutex
+	chunk    int
+	buf      []byte
+	pos      int64
+}
+
+// OpenObjectFile opens an object file. An uncompressed object file of an earlier
+// release is verified against the hash in its path (see ObjectFilePath) and rewritten
+// compressed.
+func OpenObjectFile(path string) (*ObjectFile, error) {
+	f, err := openObjectFile(path)
+	if nil != err {
+		return nil, err
+	}
+	if nil == f.offsets {
+		return migrateObjectFile(path, f)
+	}
+	return f, nil
+}
+
+// OpenRawObjectFile opens an uncompressed object file, such as an LFS object file.
+func OpenRawObjectFile(path string) (*ObjectFile, error) {
+	file, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if nil != err {
+		file.Close()
+		return nil, err
+	}
+
+	return &ObjectFile{
+		file:     file,
+		size:     info.Size(),
+		disksize: info.Size(),
+		chunk:    -1,
+	}, nil
+}
+
+func openObjectFile(path string) (*ObjectFile, error) {
+	f, err := OpenRawObjectFile(path)
+	if nil != err {
+		return nil, err
+	}
+	if offsets, size, ot, ok := readObjectFileIndex(f.file, f.disksize); ok {
+		f.offsets = offsets
+		f.size = size
+		f.otype = ot
+	}
+	return f, nil
+}
+
+// migrateObjectFile rewrites the uncompressed object file f in the current format.
+// If the object file cannot be replaced (e.g. because it is open), the verified
+// uncompressed content is used as is.
+func migrateObjectFile(path string, f *ObjectFile) (*ObjectFile, error) {
+	hash := filepath.Base(filepath.Dir(path)) + filepath.Base(path)
+	ot, ok := ObjectTypeOf(f, f.size, hash)
+	if !ok {
+		f.Close()
+		return nil, errInvalidObjectFile
+	}
+	f.otype = ot
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if nil != err {
+		return f, nil
+	}
+	writer := bufio.NewWriterSize(file, 64*1024)
+	ow := NewObjectFileWriter(writer, ot)
+	_, err = io.Copy(ow, io.NewSectionReader(f, 0, f.size))
+	if nil == err {
+		err = ow.Close()
+	}
+	if nil == err {
+		err = writer.Flush()
+	}
+	if e := file.Close(); nil == err {
+		err = e
+	}
+	if nil == err {
+		f.Close()
+		err = os.Rename(file.Name(), path)
+		if nil == err {
+			return openObjectFile(path)
+		}
+		os.Remove(file.Name())
+		f, err = OpenRawObjectFile(path)
+		if nil != err {
+			return nil, err
+		}
+		f.otype = ot
+		return f, nil
+	}
+	os.Remove(file.Name())
+	return f, nil
+}
+
+func readObjectFileIndex(file *os.File, disksize int64) (
+	offsets []int64, size int64, ot ObjectType, ok bool) {
+	m := int64(len(objectFileMagic))
+	if 2*m+16 > disksize {
+		return
+	}
+
+	var hdr [8]byte
+	var ftr [24]byte
+	if _, err := file.ReadAt(hdr[:], 0); nil != err {
+		return
+	}
+	t := int64(0)
+	switch {
+	case bytes.Equal(hdr[:], objectFileMagic):
+		/* version 2 files record the object type */
+		t = 8
+	case bytes.Equal(hdr[:], objectFileMagic1):
+	default:
+		return
+	}
+	if 2*m+16+t > disksize {
+		return
+	}
+	if _, err := file.ReadAt(ftr[:16+t], disksize-16-t); nil != err ||
+		!bytes.Equal(ftr[8+t:16+t], hdr[:]) {
+		return
+	}
+
+	size = int64(binary.LittleEndian.Uint64(ftr[:8]))
+	if 0 > size {
+		return
+	}
+	if 0 < t {
+		ot = ObjectType(binary.LittleEndian.Uint64(ftr[8:16]))
+		if CommitObject > ot || TagObject < ot {
+			return
+		}
+	}
+	n := (size + objectChunkSize - 1) / objectChunkSize
+	start := disksize - 16 - t - 8*(n+1)
+	if m > start {
+		return
+	}
+
+	tail := make([]byte, 8*(n+1))
+	if _, err := file.ReadAt(tail, start); nil != err {
+		return
+	}
+	offsets = make([]int64, n+1)
+	prev := m
+	for i := range offsets {
+		offsets[i] = int64(binary.LittleEndian.Uint64(tail[8*i:]))
+		if prev > offsets[i] || (0 == i && m != offsets[i]) {
+			return nil, 0, 0, false
+		}
+		prev = offsets[i]
+	}
+	if start != offsets[n] {
+		return nil, 0, 0, false
+	}
+
+	return offsets, size, ot, true
+}
+
+// Size returns the size of the object content.
+func (f *ObjectFile) Size() int64 {
+	return f.size
+}
+
+// Type returns the object type or 0 if the object file does not record it.
+func (f *ObjectFile) Type() ObjectType {
+	return f.otype
+}
+
+// DiskSize returns the size of the object file on disk.
+func (f *ObjectFile) DiskSize() int64 {
+	return f.disksize
+}
+
+// readChunk reads and decompresses a chunk. It must be called with the lock held.
+func (f *ObjectFile) readChunk(i int) error {
+	if i == f.chunk {
+		return nil
+	}
+	f.chunk = -1
+
+	o, e := f.offsets[i], f.offsets[i+1]
+	reader := flate.NewReader(io.NewSectionReader(f.file, o, e-o))
+	defer reader.Close()
+
+	n := int64(objectChunkSize)
+	if int64(len(f.offsets)-2) == int64(i) {
+		n = f.size - int64(i)*objectChunkSize
+	}
+	if int64(cap(f.buf)) < n {
+		f.buf = make([]byte, n)
+	}
+	f.buf = f.buf[:n]
+	if _, err := io.ReadFull(reader, f.buf); nil != err {
+		if io.EOF == err || io.ErrUnexpectedEOF == err {
+			err = errInvalidObjectFile
+		}
+		return err
+	}
+
+	f.chunk = i
+	return nil
+}
+
+func (f *ObjectFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if nil == f.offsets {
+		return f.file.ReadAt(p, off)
+	}
+
+	if 0 > off {
+		return 0, os.ErrInvalid
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for 0 < len(p) {
+		if off >= f.size {
+			return n, io.EOF
+		}
+		i := int(off / objectChunkSize)
+		err = f.readChunk(i)
+		if nil != err {
+			return
+		}
+		m := copy(p, f.buf[off-int64(i)*objectChunkSize:])
+		n += m
+		off += int64(m)
+		p = p[m:]
+	}
+
+	return n, nil
+}
+
+func (f *ObjectFile) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if 0 < n && io.EOF == err {
+		err = nil
+	}
+	return
+}
+
+func (f *ObjectFile) Close() error {
+	return f.file.Close()
+}
+
+// ReadObjectFile reads the entire content of an object file.
+func ReadObjectFile(path string) ([]byte, error) {
+	f, err := OpenObjectFile(path)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	content := make([]byte, f.Size())
+	_, err = f.ReadAt(content, 0)
+	if io.EOF == err {
+		err = nil
+	}
+	return content, err
+}
+
+// ObjectTypeOf determines the type of an object from its content and hash. If reader
+// is an object file that records the object type, only that type is checked;
+// otherwise the content is checked against each object type.
+func ObjectTypeOf(reader io.ReaderAt, size int64, hash string) (ObjectType, bool) {
+	var ot ObjectType
+	if f, ok := reader.(interface{ Type() ObjectType }); ok {
+		ot = f.Type()
+	}
+	return objectTypeOf(reader, size, ot, hash)
+}
+
+func objectTypeOf(reader io.ReaderAt, size int64, ot ObjectType, hash string) (ObjectType, bool) {
+	types := []ObjectType{BlobObject, TreeObject, CommitObject, TagObject}
+	if 0 != ot {
+		types = []ObjectType{ot}
+	}
+	for _, ot := range types {
+		hasher := plumbing.NewHasher(plumbing.ObjectType(ot), size)
+		n, err := io.Copy(hasher, io.NewSectionReader(reader, 0, size))
+		if nil != err || size != n {
+			return 0, false
+		}
+		if hash == hasher.Sum().String() {
+			return ot, true
+		}
+	}
+	return 0, false
+}
+
+// VerifyObject verifies that the content of an object of type ot matches its hash.
+// An ot of 0 denotes an unknown object type (see ObjectTypeOf).
+func VerifyObject(reader io.ReaderAt, size int64, ot ObjectType, hash string) bool {
+	var ok bool
+	if 0 != ot {
+		_, ok = objectTypeOf(reader, size, ot, hash)
+	} else {
+		_, ok = ObjectTypeOf(reader, size, hash)
+	}
+	return ok
+}
@@ -0,0 +1,223 @@
+/*
+ * objfile_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObjectFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "objfile_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rnd := rand.New(rand.NewSource(42))
+	random := make([]byte, 3*objectChunkSize+1000)
+	rnd.Read(random)
+
+	contents := [][]byte{
+		{},
+		[]byte("hello\n"),
+		bytes.Repeat([]byte("0123456789abcdef"), objectChunkSize/16),
+		bytes.Repeat([]byte("0123456789abcdef\n"), 20000),
+		random,
+	}
+
+	for i, content := range contents {
+		p := filepath.Join(dir, "object")
+		file, err := os.Create(p)
+		if nil != err {
+			t.Fatal(err)
+		}
+		writer := NewObjectFileWriter(file, BlobObject)
+		for c := content; 0 < len(c); {
+			n := 1000 + rnd.Intn(50000)
+			if n > len(c) {
+				n = len(c)
+			}
+			writer.Write(c[:n])
+			c = c[n:]
+		}
+		err = writer.Close()
+		file.Close()
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		res, err := ReadObjectFile(p)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, res) {
+			t.Errorf("content %d mismatch", i)
+		}
+
+		f, err := OpenObjectFile(p)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if int64(len(content)) != f.Size() {
+			t.Error(i, f.Size())
+		}
+		if BlobObject != f.Type() {
+			t.Error(i, f.Type())
+		}
+		if 3 == i && f.DiskSize() >= f.Size() {
+			t.Error(i, f.DiskSize())
+		}
+		if 0 < len(content) {
+			for j := 0; 100 > j; j++ {
+				off := rnd.Intn(len(content))
+				buf := make([]byte, rnd.Intn(2*objectChunkSize))
+				n, err := f.ReadAt(buf, int64(off))
+				e := len(content) - off
+				if e > len(buf) {
+					e = len(buf)
+				}
+				if e != n || (n < len(buf) && io.EOF != err) || (n == len(buf) && nil != err) {
+					t.Error(i, off, len(buf), n, err)
+				}
+				if !bytes.Equal(content[off:off+n], buf[:n]) {
+					t.Errorf("content %d mismatch at %d", i, off)
+				}
+			}
+		}
+		res, err = ioutil.ReadAll(f)
+		if nil != err || !bytes.Equal(content, res) {
+			t.Errorf("content %d mismatch", i)
+		}
+		f.Close()
+	}
+
+	// version 1 object files do not record the object type
+	p := filepath.Join(dir, "object")
+	res, err := ioutil.ReadFile(p)
+	if nil != err {
+		t.Fatal(err)
+	}
+	res = append(res[:len(res)-16], objectFileMagic1...)
+	copy(res, objectFileMagic1)
+	err = ioutil.WriteFile(p, res, 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+	f, err := OpenObjectFile(p)
+	if nil != err {
+		t.Fatal(err)
+	}
+	res, err = ioutil.ReadAll(f)
+	if nil != err || !bytes.Equal(contents[len(contents)-1], res) || 0 != f.Type() {
+		t.Error()
+	}
+	f.Close()
+}
+
+func TestObjectFileMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "objfile_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// uncompressed object files of earlier releases are rewritten on open
+	hash := "ce013625030ba8dba906f756967f9e9ca394464a"
+	p := ObjectFilePath(dir, hash)
+	os.MkdirAll(filepath.Dir(p), 0700)
+	err = ioutil.WriteFile(p, []byte("hello\n"), 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+	f, err := OpenObjectFile(p)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if BlobObject != f.Type() || 6 != f.Size() {
+		t.Error(f.Type(), f.Size())
+	}
+	f.Close()
+	res, err := ioutil.ReadFile(p)
+	if nil != err || !bytes.HasPrefix(res, objectFileMagic) {
+		t.Error(err)
+	}
+	res, err = ReadObjectFile(p)
+	if nil != err || "hello\n" != string(res) {
+		t.Error(err)
+	}
+
+	// uncompressed object files that do not match their hash are invalid
+	p = ObjectFilePath(dir, "0000000000000000000000000000000000000000")
+	os.MkdirAll(filepath.Dir(p), 0700)
+	err = ioutil.WriteFile(p, []byte("hello\n"), 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, err = OpenObjectFile(p)
+	if errInvalidObjectFile != err {
+		t.Error(err)
+	}
+
+	// raw object files are read as is
+	f, err = OpenRawObjectFile(p)
+	if nil != err {
+		t.Fatal(err)
+	}
+	res, err = ioutil.ReadAll(f)
+	if nil != err || "hello\n" != string(res) || 0 != f.Type() {
+		t.Error(err)
+	}
+	f.Close()
+}
+
+func TestVerifyObject(t *testing.T) {
+	content := []byte("hello\n")
+	if !VerifyObject(bytes.NewReader(content), int64(len(content)), 0,
+		"ce013625030ba8dba906f756967f9e9ca394464a") {
+		t.Error()
+	}
+	if !VerifyObject(bytes.NewReader(content), int64(len(content)), BlobObject,
+		"ce013625030ba8dba906f756967f9e9ca394464a") {
+		t.Error()
+	}
+	if VerifyObject(bytes.NewReader(content), int64(len(content)), TreeObject,
+		"ce013625030ba8dba906f756967f9e9ca394464a") {
+		t.Error()
+	}
+	content = []byte("hellO\n")
+	if VerifyObject(bytes.NewReader(content), int64(len(content)), 0,
+		"ce013625030ba8dba906f756967f9e9ca394464a") {
+		t.Error()
+	}
+	if VerifyObject(bytes.NewReader(content[:3]), int64(len(content)), 0,
+		"ce013625030ba8dba906f756967f9e9ca394464a") {
+		t.Error()
+	}
+
+	content = []byte("hello\n")
+	if !VerifyLfsObject(bytes.NewReader(content), int64(len(content)),
+		"5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03") {
+		t.Error()
+	}
+	if VerifyLfsObject(bytes.NewReader(content), int64(len(content)),
+		"0000b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03") {
+		t.Error()
+	}
+}
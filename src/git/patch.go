@@ -0,0 +1,198 @@
+/*
+ * patch.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/utils/binary"
+	utildiff "github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// PatchFile is a change to a file that is encoded by EncodePatch. The old mode of an added
+// file and the new mode of a deleted file are 0.
+type PatchFile struct {
+	Path    string
+	OldMode uint32
+	OldHash string
+	Old     []byte
+	NewMode uint32
+	NewHash string
+	New     []byte
+}
+
+type patch []diff.FilePatch
+
+func (p patch) FilePatches() []diff.FilePatch {
+	return p
+}
+
+func (p patch) Message() string {
+	return ""
+}
+
+type patchFile struct {
+	from, to diff.File
+	binary   bool
+	chunks   []diff.Chunk
+}
+
+func (f *patchFile) IsBinary() bool {
+	return f.binary
+}
+
+func (f *patchFile) Files() (diff.File, diff.File) {
+	return f.from, f.to
+}
+
+func (f *patchFile) Chunks() []diff.Chunk {
+	return f.chunks
+}
+
+type patchEntry struct {
+	path string
+	mode uint32
+	hash string
+}
+
+func (e *patchEntry) Hash() plumbing.Hash {
+	return plumbing.NewHash(e.hash)
+}
+
+func (e *patchEntry) Mode() filemode.FileMode {
+	return filemode.FileMode(e.mode)
+}
+
+func (e *patchEntry) Path() string {
+	return e.path
+}
+
+type patchChunk struct {
+	content string
+	op      diff.Operation
+}
+
+func (c *patchChunk) Content() string {
+	return c.content
+}
+
+func (c *patchChunk) Type() diff.Operation {
+	return c.op
+}
+
+func isBinary(content []byte) bool {
+	res, _ := binary.IsBinary(bytes.NewReader(content))
+	return res
+}
+
+// encodeBinaryHunk writes content as a literal hunk of a git binary patch: the content
+// is compressed with zlib and encoded in lines of up to 52 bytes using git's base85.
+func encodeBinaryHunk(buf *bytes.Buffer, content []byte) {
+	const alphabet = "0123456789" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"abcdefghijklmnopqrstuvwxyz" +
+		"!#$%&()*+-;<=>?@^_`{|}~"
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(content)
+	zw.Close()
+
+	fmt.Fprintf(buf, "literal %d\n", len(content))
+	for data := z.Bytes(); 0 < len(data); {
+		n := len(data)
+		if 52 < n {
+			n = 52
+		}
+		if 26 >= n {
+			buf.WriteByte(byte('A' + n - 1))
+		} else {
+			buf.WriteByte(byte('a' + n - 27))
+		}
+		for i := 0; n > i; i += 4 {
+			var acc uint32
+			for j := 0; 4 > j; j++ {
+				acc <<= 8
+				if n > i+j {
+					acc |= uint32(data[i+j])
+				}
+			}
+			var enc [5]byte
+			for j := 4; 0 <= j; j-- {
+				enc[j] = alphabet[acc%85]
+				acc /= 85
+			}
+			buf.Write(enc[:])
+		}
+		buf.WriteByte('\n')
+		data = data[n:]
+	}
+	buf.WriteByte('\n')
+}
+
+// EncodePatch writes the changes to files as a patch in the format of git diff, which
+// can be applied with git apply. Binary files are included as git binary patches that
+// contain the full old and new content.
+func EncodePatch(w io.Writer, files []*PatchFile) error {
+	for _, f := range files {
+		pf := &patchFile{}
+		if 0 != f.OldMode {
+			pf.from = &patchEntry{f.Path, f.OldMode, f.OldHash}
+		}
+		if 0 != f.NewMode {
+			pf.to = &patchEntry{f.Path, f.NewMode, f.NewHash}
+		}
+		pf.binary = isBinary(f.Old) || isBinary(f.New)
+		if !pf.binary {
+			for _, d := range utildiff.Do(string(f.Old), string(f.New)) {
+				op := diff.Equal
+				switch d.Type {
+				case diffmatchpatch.DiffInsert:
+					op = diff.Add
+				case diffmatchpatch.DiffDelete:
+					op = diff.Delete
+				}
+				pf.chunks = append(pf.chunks, &patchChunk{d.Text, op})
+			}
+		}
+
+		var buf bytes.Buffer
+		err := diff.NewUnifiedEncoder(&buf, diff.DefaultContextLines).Encode(patch{pf})
+		if nil != err {
+			return err
+		}
+
+		// replace the "Binary files differ" line with the binary patch
+		if i := bytes.LastIndex(buf.Bytes(), []byte("\nBinary files ")); pf.binary && -1 != i {
+			buf.Truncate(i + 1)
+			buf.WriteString("GIT binary patch\n")
+			encodeBinaryHunk(&buf, f.New)
+			encodeBinaryHunk(&buf, f.Old)
+		}
+
+		_, err = w.Write(buf.Bytes())
+		if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+ * patch_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEncodePatch(t *testing.T) {
+	file := func(path string, oldmode uint32, old string, newmode uint32, new string) *PatchFile {
+		f := &PatchFile{Path: path, OldMode: oldmode, NewMode: newmode}
+		if 0 != oldmode {
+			f.OldHash, _ = NewObject(BlobObject, []byte(old))
+			f.Old = []byte(old)
+		}
+		if 0 != newmode {
+			f.NewHash, _ = NewObject(BlobObject, []byte(new))
+			f.New = []byte(new)
+		}
+		return f
+	}
+
+	var buf bytes.Buffer
+	err := EncodePatch(&buf, []*PatchFile{
+		file("mod", 0100644, "1\n2\n3\n", 0100755, "1\nTWO\n3\n"),
+		file("new", 0, "", 0100644, "new\n"),
+		file("old", 0100644, "old\n", 0, ""),
+		file("bin", 0100644, "a\x00b", 0100644, "a\x00c"),
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	expected := "" +
+		"diff --git a/mod b/mod\n" +
+		"old mode 100644\n" +
+		"new mode 100755\n" +
+		"index 01e79c32a8c99c557f0757da7cb6d65b3414466d..230b143ae0f400f75a1f4e292c27840a759ec8c5\n" +
+		"--- a/mod\n" +
+		"+++ b/mod\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" 1\n" +
+		"-2\n" +
+		"+TWO\n" +
+		" 3\n" +
+		"diff --git a/new b/new\n" +
+		"new file mode 100644\n" +
+		"index 0000000000000000000000000000000000000000..3e757656cf36eca53338e520d134963a44f793f8\n" +
+		"--- /dev/null\n" +
+		"+++ b/new\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+new\n" +
+		"diff --git a/old b/old\n" +
+		"deleted file mode 100644\n" +
+		"index 3367afdbbf91e638efe983616377c60477cc6612..0000000000000000000000000000000000000000\n" +
+		"--- a/old\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-old\n"
+	if !bytes.HasPrefix(buf.Bytes(), []byte(expected)) {
+		t.Error(buf.String())
+	}
+	binary := "" +
+		"diff --git a/bin b/bin\n" +
+		"index 20b5be91886d0b6f26dc98a225c0dac05fe2c86e..88f37001cec36655decf891d4244853aaa51a00a 100644\n" +
+		"GIT binary patch\n"
+	i := bytes.Index(buf.Bytes(), []byte(binary))
+	if -1 == i {
+		t.Fatal(buf.String())
+	}
+	hunks := strings.Split(buf.String()[i+len(binary):], "\n\n")
+	if 3 != len(hunks) || "" != hunks[2] ||
+		"a\x00c" != testDecodeBinaryHunk(t, hunks[0]) ||
+		"a\x00b" != testDecodeBinaryHunk(t, hunks[1]) {
+		t.Error(buf.String())
+	}
+}
+
+func testDecodeBinaryHunk(t *testing.T, hunk string) string {
+	const alphabet = "0123456789" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"abcdefghijklmnopqrstuvwxyz" +
+		"!#$%&()*+-;<=>?@^_`{|}~"
+
+	lines := strings.Split(hunk, "\n")
+	var size int
+	if _, err := fmt.Sscanf(lines[0], "literal %d", &size); nil != err {
+		t.Fatal(err)
+	}
+
+	var z bytes.Buffer
+	for _, line := range lines[1:] {
+		n := int(line[0]-'A') + 1
+		if 'a' <= line[0] {
+			n = int(line[0]-'a') + 27
+		}
+		var data []byte
+		for i := 1; len(line) > i; i += 5 {
+			var acc uint32
+			for _, c := range []byte(line[i : i+5]) {
+				acc = acc*85 + uint32(strings.IndexByte(alphabet, c))
+			}
+			data = append(data, byte(acc>>24), byte(acc>>16), byte(acc>>8), byte(acc))
+		}
+		z.Write(data[:n])
+	}
+
+	zr, err := zlib.NewReader(&z)
+	if nil != err {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(zr)
+	if nil != err || size != len(content) {
+		t.Fatal(err)
+	}
+	return string(content)
+}
@@ -0,0 +1,170 @@
+/*
+ * push.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+)
+
+// Object is an object to be pushed.
+type Object struct {
+	Type    ObjectType
+	Content []byte
+}
+
+var ErrRefChanged = errors.New("ref has changed")
+
+// NewObject returns an object and its hash.
+func NewObject(ot ObjectType, content []byte) (string, *Object) {
+	return plumbing.ComputeHash(plumbing.ObjectType(ot), content).String(), &Object{ot, content}
+}
+
+// HashObject computes the hash of an object whose content is read from reader.
+func HashObject(ot ObjectType, size int64, reader io.Reader) (string, error) {
+	hasher := plumbing.NewHasher(plumbing.ObjectType(ot), size)
+	n, err := io.Copy(hasher, reader)
+	if nil != err {
+		return "", err
+	}
+	if size != n {
+		return "", io.ErrUnexpectedEOF
+	}
+	return hasher.Sum().String(), nil
+}
+
+// EncodeTree returns the content of a tree object. The entries are sorted in the order
+// that git requires, where directory names sort as if they end with a slash.
+func EncodeTree(entries []*TreeEntry) ([]byte, error) {
+	key := func(e *TreeEntry) string {
+		if 0040000 == e.Mode {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	lst := make([]*TreeEntry, len(entries))
+	copy(lst, entries)
+	sort.Slice(lst, func(i, j int) bool { return key(lst[i]) < key(lst[j]) })
+
+	t := &object.Tree{}
+	for _, e := range lst {
+		t.Entries = append(t.Entries, object.TreeEntry{
+			Name: e.Name,
+			Mode: filemode.FileMode(e.Mode),
+			Hash: plumbing.NewHash(e.Hash),
+		})
+	}
+	return encodeObject(t)
+}
+
+// EncodeCommit returns the content of a commit object.
+func EncodeCommit(tree string, parents []string,
+	author Signature, committer Signature, message string) ([]byte, error) {
+	c := &object.Commit{
+		Author:    object.Signature{Name: author.Name, Email: author.Email, When: author.Time},
+		Committer: object.Signature{Name: committer.Name, Email: committer.Email, When: committer.Time},
+		Message:   message,
+		TreeHash:  plumbing.NewHash(tree),
+	}
+	for _, p := range parents {
+		c.ParentHashes = append(c.ParentHashes, plumbing.NewHash(p))
+	}
+	return encodeObject(c)
+}
+
+func encodeObject(o interface {
+	Encode(plumbing.EncodedObject) error
+}) ([]byte, error) {
+	obj := &plumbing.MemoryObject{}
+	err := o.Encode(obj)
+	if nil != err {
+		return nil, err
+	}
+	reader, err := obj.Reader()
+	if nil != err {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func newStoremap(objects map[string]*Object) (stg storemap, hashes []plumbing.Hash) {
+	stg = storemap{}
+	for _, o := range objects {
+		obj := &plumbing.MemoryObject{}
+		obj.SetType(plumbing.ObjectType(o.Type))
+		obj.Write(o.Content)
+		hash, _ := stg.SetEncodedObject(obj)
+		hashes = append(hashes, hash)
+	}
+	return
+}
+
+// Push sends objects to the remote and updates a ref from oldhash (empty for a new ref)
+// to newhash. The update fails if the ref no longer has the value oldhash.
+func (repository *Repository) Push(refname string, oldhash string, newhash string,
+	objects map[string]*Object) (err error) {
+	defer trace(refname, oldhash, newhash, len(objects))(&err)
+
+	if nil == repository.client {
+		return ErrNotSupported
+	}
+
+	session, err := repository.client.NewReceivePackSession(repository.endpoint, repository.auth)
+	if nil != err {
+		return err
+	}
+	defer session.Close()
+
+	advrefs, err := session.AdvertisedReferences()
+	if nil != err {
+		return err
+	}
+
+	old := plumbing.ZeroHash
+	if "" != oldhash {
+		old = plumbing.NewHash(oldhash)
+	}
+	if ref, ok := advrefs.References[refname]; (ok && old != ref) || (!ok && !old.IsZero()) {
+		return ErrRefChanged
+	}
+
+	req := packp.NewReferenceUpdateRequestFromCapabilities(advrefs.Capabilities)
+	req.Commands = []*packp.Command{{
+		Name: plumbing.ReferenceName(refname),
+		Old:  old,
+		New:  plumbing.NewHash(newhash),
+	}}
+
+	stg, hashes := newStoremap(objects)
+	var buf bytes.Buffer
+	_, err = packfile.NewEncoder(&buf, stg, false).Encode(hashes, 0)
+	if nil != err {
+		return err
+	}
+	req.Packfile = ioutil.NopCloser(&buf)
+
+	_, err = session.ReceivePack(context.Background(), req)
+	return err
+}
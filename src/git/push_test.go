@@ -0,0 +1,140 @@
+/*
+ * push_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+)
+
+func TestEncodeObjects(t *testing.T) {
+	hash, _ := NewObject(TreeObject, []byte{})
+	if "4b825dc642cb6eb9a060e54bf8d69288fbee4904" != hash {
+		t.Error(hash)
+	}
+
+	blob, _ := NewObject(BlobObject, []byte("hello\n"))
+	if "ce013625030ba8dba906f756967f9e9ca394464a" != blob {
+		t.Error(blob)
+	}
+	h, err := HashObject(BlobObject, 6, bytes.NewReader([]byte("hello\n")))
+	if nil != err || blob != h {
+		t.Error(h, err)
+	}
+	_, err = HashObject(BlobObject, 7, bytes.NewReader([]byte("hello\n")))
+	if nil == err {
+		t.Error()
+	}
+
+	content, err := EncodeTree([]*TreeEntry{
+		{Name: "a", Mode: 0040000, Hash: hash},
+		{Name: "a.b", Mode: 0100644, Hash: blob},
+		{Name: "B", Mode: 0100755, Hash: blob},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	entries, err := DecodeTree(content)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != len(entries) ||
+		"B" != entries[0].Name || "a.b" != entries[1].Name || "a" != entries[2].Name ||
+		0100755 != entries[0].Mode || 0040000 != entries[2].Mode || blob != entries[1].Hash {
+		t.Error(entries)
+	}
+
+	sig := Signature{Name: "test", Email: "test@example.com", Time: time.Unix(1600000000, 0)}
+	content, err = EncodeCommit(hash, []string{blob}, sig, sig, "message\n")
+	if nil != err {
+		t.Fatal(err)
+	}
+	commit, err := DecodeCommit(content)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if hash != commit.TreeHash || "test" != commit.Author.Name ||
+		"test@example.com" != commit.Committer.Email || 1600000000 != commit.Author.Time.Unix() {
+		t.Error(commit)
+	}
+}
+
+func TestLocalPush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = gogit.PlainInit(dir, true)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	repository, err := OpenLocalRepository(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	objects := map[string]*Object{}
+	blob, obj := NewObject(BlobObject, []byte("hello\n"))
+	objects[blob] = obj
+	content, _ := EncodeTree([]*TreeEntry{{Name: "file", Mode: 0100644, Hash: blob}})
+	tree, obj := NewObject(TreeObject, content)
+	objects[tree] = obj
+	sig := Signature{Name: "test", Email: "test@example.com", Time: time.Now()}
+	content, _ = EncodeCommit(tree, nil, sig, sig, "message\n")
+	commit, obj := NewObject(CommitObject, content)
+	objects[commit] = obj
+
+	err = repository.Push("refs/heads/master", blob, commit, objects)
+	if ErrRefChanged != err {
+		t.Error(err)
+	}
+	err = repository.Push("refs/heads/master", "", commit, objects)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = repository.Push("refs/heads/master", "", commit, objects)
+	if ErrRefChanged != err {
+		t.Error(err)
+	}
+
+	refs, err := repository.GetRefs()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if commit != refs["refs/heads/master"] {
+		t.Error(refs)
+	}
+
+	res := map[string]ObjectType{}
+	err = repository.FetchObjects([]string{commit, tree, blob},
+		func(hash string, ot ObjectType, content []byte) error {
+			res[hash] = ot
+			return nil
+		})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if CommitObject != res[commit] || TreeObject != res[tree] || BlobObject != res[blob] {
+		t.Error(res)
+	}
+}
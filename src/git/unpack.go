@@ -0,0 +1,271 @@
+/*
+ * unpack.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+)
+
+var errInvalidDelta = errors.New("invalid delta")
+
+// ObjectFilePath returns the path of the file that stores the content of an object
+// under dir.
+func ObjectFilePath(dir string, hash string) string {
+	if 2 < len(hash) {
+		return filepath.Join(dir, hash[:2], hash[2:])
+	}
+	return ""
+}
+
+// createObjectFile writes an object to a temporary file in dir using the function fn.
+// The object hash is computed while the object is being written and the file is then
+// renamed to its final path. The object file is compressed (see ObjectFile).
+func createObjectFile(dir string, ot plumbing.ObjectType, size int64,
+	fn func(w io.Writer) error) (hash string, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if nil != err {
+		return
+	}
+	file, err := ioutil.TempFile(dir, ".tmp")
+	if nil != err {
+		return
+	}
+	tmp := file.Name()
+	defer func() {
+		if nil != err {
+			os.Remove(tmp)
+		}
+	}()
+
+	hasher := plumbing.NewHasher(ot, size)
+	writer := bufio.NewWriterSize(file, 64*1024)
+	ow := NewObjectFileWriter(writer, ObjectType(ot))
+	err = fn(io.MultiWriter(ow, hasher))
+	if nil == err {
+		err = ow.Close()
+	}
+	if nil == err {
+		err = writer.Flush()
+	}
+	if e := file.Close(); nil == err {
+		err = e
+	}
+	if nil != err {
+		return
+	}
+
+	hash = hasher.Sum().String()
+	p := ObjectFilePath(dir, hash)
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if nil == err {
+		err = os.Rename(tmp, p)
+		if nil != err {
+			if _, e := os.Stat(p); nil == e {
+				// object already present (possibly open and unable to be replaced)
+				os.Remove(tmp)
+				err = nil
+			}
+		}
+	}
+	return
+}
+
+func readDeltaSize(r io.ByteReader) (int64, error) {
+	var size int64
+	for shift := uint(0); ; shift += 7 {
+		b, err := r.ReadByte()
+		if nil != err {
+			return 0, err
+		}
+		size |= int64(b&0x7f) << shift
+		if 0 == b&0x80 {
+			return size, nil
+		}
+		if 63 < shift {
+			return 0, errInvalidDelta
+		}
+	}
+}
+
+// patchDelta applies a delta to the base object and writes the result to w. Base
+// content is read on demand so that memory use is independent of object size.
+func patchDelta(base io.ReaderAt, baseSize int64, delta *bufio.Reader, w io.Writer) error {
+	for {
+		cmd, err := delta.ReadByte()
+		if io.EOF == err {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+		if 0 != cmd&0x80 {
+			var offset, size int64
+			for i := uint(0); 4 > i; i++ {
+				if 0 != cmd&(1<<i) {
+					b, err := delta.ReadByte()
+					if nil != err {
+						return err
+					}
+					offset |= int64(b) << (8 * i)
+				}
+			}
+			for i := uint(0); 3 > i; i++ {
+				if 0 != cmd&(0x10<<i) {
+					b, err := delta.ReadByte()
+					if nil != err {
+						return err
+					}
+					size |= int64(b) << (8 * i)
+				}
+			}
+			if 0 == size {
+				size = 0x10000
+			}
+			if offset+size > baseSize {
+				return errInvalidDelta
+			}
+			_, err = io.Copy(w, io.NewSectionReader(base, offset, size))
+			if nil != err {
+				return err
+			}
+		} else if 0 != cmd {
+			_, err = io.CopyN(w, delta, int64(cmd))
+			if nil != err {
+				return err
+			}
+		} else {
+			return errInvalidDelta
+		}
+	}
+}
+
+// createDeltaObjectFile resolves a delta against a base object stored in dir.
+func createDeltaObjectFile(dir string, ot plumbing.ObjectType, base string,
+	deltaFile *os.File) (hash string, err error) {
+	_, err = deltaFile.Seek(0, io.SeekStart)
+	if nil != err {
+		return
+	}
+	delta := bufio.NewReaderSize(deltaFile, 64*1024)
+
+	baseSize, err := readDeltaSize(delta)
+	if nil != err {
+		return
+	}
+	targetSize, err := readDeltaSize(delta)
+	if nil != err {
+		return
+	}
+
+	baseFile, err := OpenObjectFile(ObjectFilePath(dir, base))
+	if nil != err {
+		return
+	}
+	defer baseFile.Close()
+
+	if baseSize != baseFile.Size() {
+		return "", errInvalidDelta
+	}
+
+	return createObjectFile(dir, ot, targetSize, func(w io.Writer) error {
+		return patchDelta(baseFile, baseSize, delta, w)
+	})
+}
+
+// unpackObjectFiles reads a packfile and stores each object in a file under dir.
+// Objects are streamed to disk; deltas are spooled to disk and resolved against base
+// objects on disk.
+func unpackObjectFiles(reader io.Reader, dir string,
+	fn func(hash string, ot ObjectType) error) (err error) {
+	scn := packfile.NewScanner(reader)
+	_, count, err := scn.Header()
+	if nil != err {
+		return err
+	}
+
+	type object struct {
+		hash string
+		ot   plumbing.ObjectType
+	}
+	offsets := make(map[int64]object)
+	types := make(map[string]plumbing.ObjectType)
+
+	for i := uint32(0); count > i; i++ {
+		hdr, err := scn.NextObjectHeader()
+		if nil != err {
+			return err
+		}
+
+		var obj object
+		switch hdr.Type {
+		case plumbing.CommitObject, plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject:
+			obj.ot = hdr.Type
+			obj.hash, err = createObjectFile(dir, hdr.Type, hdr.Length, func(w io.Writer) error {
+				_, _, err := scn.NextObject(w)
+				return err
+			})
+		case plumbing.OFSDeltaObject, plumbing.REFDeltaObject:
+			var base object
+			var ok bool
+			if plumbing.OFSDeltaObject == hdr.Type {
+				base, ok = offsets[hdr.OffsetReference]
+			} else {
+				base.hash = hdr.Reference.String()
+				base.ot, ok = types[base.hash]
+			}
+			if !ok {
+				return errInvalidDelta
+			}
+			obj.ot = base.ot
+			obj.hash, err = func() (string, error) {
+				deltaFile, err := ioutil.TempFile(dir, ".tmp")
+				if nil != err {
+					return "", err
+				}
+				defer func() {
+					deltaFile.Close()
+					os.Remove(deltaFile.Name())
+				}()
+				_, _, err = scn.NextObject(deltaFile)
+				if nil != err {
+					return "", err
+				}
+				return createDeltaObjectFile(dir, base.ot, base.hash, deltaFile)
+			}()
+		default:
+			return plumbing.ErrInvalidType
+		}
+		if nil != err {
+			return err
+		}
+
+		offsets[hdr.Offset] = obj
+		types[obj.hash] = obj.ot
+
+		err = fn(obj.hash, ObjectType(obj.ot))
+		if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+ * unpack_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func TestUnpackObjectFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unpack_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []string{
+		strings.Repeat("0123456789abcdef\n", 10000),
+		strings.Repeat("0123456789abcdef\n", 10000) + "tail\n",
+		"head\n" + strings.Repeat("0123456789abcdef\n", 9000),
+	}
+
+	storage := memory.NewStorage()
+	hashes := make([]plumbing.Hash, len(content))
+	for i, c := range content {
+		obj := storage.NewEncodedObject()
+		obj.SetType(plumbing.BlobObject)
+		w, _ := obj.Writer()
+		w.Write([]byte(c))
+		w.Close()
+		hashes[i], err = storage.SetEncodedObject(obj)
+		if nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	for _, refdelta := range []bool{false, true} {
+		var pack bytes.Buffer
+		_, err = packfile.NewEncoder(&pack, storage, refdelta).Encode(hashes, 10)
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		found := make(map[string]bool)
+		err = unpackObjectFiles(&pack, dir, func(hash string, ot ObjectType) error {
+			if BlobObject != ot {
+				t.Error()
+			}
+			found[hash] = true
+			return nil
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		for i, h := range hashes {
+			if !found[h.String()] {
+				t.Error()
+			}
+			data, err := ReadObjectFile(ObjectFilePath(dir, h.String()))
+			if nil != err {
+				t.Error(err)
+			} else if content[i] != string(data) {
+				t.Error()
+			}
+		}
+
+		infos, _ := ioutil.ReadDir(dir)
+		for _, info := range infos {
+			if strings.HasPrefix(info.Name(), ".tmp") {
+				t.Error(info.Name())
+			}
+		}
+	}
+}
@@ -0,0 +1,431 @@
+/*
+ * v2.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// v2Client implements the git wire protocol version 2 over smart HTTP.
+type v2Client struct {
+	httpClient *http.Client
+	url        string
+	username   string
+	password   string
+	caps       map[string]string
+}
+
+const (
+	pktData = iota
+	pktFlush
+	pktDelim
+	pktResponseEnd
+)
+
+func readPkt(r io.Reader, buf []byte) (kind int, line []byte, err error) {
+	var hdr [4]byte
+	_, err = io.ReadFull(r, hdr[:])
+	if nil != err {
+		return
+	}
+	n, err := strconv.ParseUint(string(hdr[:]), 16, 16)
+	if nil != err {
+		return
+	}
+	switch n {
+	case 0:
+		return pktFlush, nil, nil
+	case 1:
+		return pktDelim, nil, nil
+	case 2:
+		return pktResponseEnd, nil, nil
+	case 3:
+		return 0, nil, errors.New("invalid pkt-line length")
+	}
+	n -= 4
+	if uint64(len(buf)) < n {
+		return 0, nil, errors.New("invalid pkt-line length")
+	}
+	line = buf[:n]
+	_, err = io.ReadFull(r, line)
+	if nil != err {
+		return
+	}
+	return pktData, line, nil
+}
+
+func writePkt(w *bytes.Buffer, s string) {
+	fmt.Fprintf(w, "%04x%s", len(s)+4, s)
+}
+
+func httpError(rsp *http.Response) error {
+	switch rsp.StatusCode {
+	case 401:
+		return transport.ErrAuthenticationRequired
+	case 403:
+		return transport.ErrAuthorizationFailed
+	case 404:
+		return transport.ErrRepositoryNotFound
+	}
+	return errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+}
+
+// openV2 performs capability discovery. If the server does not support protocol version
+// 2 it returns a nil client and the version 1 reference advertisement that the server
+// sent instead, so that the caller does not need to request it again. The advertisement
+// is nil if the request was redirected, because the session that uses it must then use
+// the redirected endpoint.
+func openV2(httpClient *http.Client, endpoint *transport.Endpoint,
+	username string, password string) (res *v2Client, advrefs *packp.AdvRefs, err error) {
+	c := &v2Client{
+		httpClient: httpClient,
+		url:        endpoint.String(),
+		username:   username,
+		password:   password,
+		caps:       make(map[string]string),
+	}
+
+	req, err := http.NewRequest("GET", c.url+"/info/refs?service=git-upload-pack", nil)
+	if nil != err {
+		return nil, nil, err
+	}
+	c.setHeaders(req)
+
+	rsp, err := c.httpClient.Do(req)
+	if nil != err {
+		return nil, nil, err
+	}
+	defer rsp.Body.Close()
+
+	if 400 <= rsp.StatusCode {
+		return nil, nil, httpError(rsp)
+	}
+
+	content, err := ioutil.ReadAll(rsp.Body)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	v1 := func() (*v2Client, *packp.AdvRefs, error) {
+		if req != rsp.Request {
+			return nil, nil, nil
+		}
+		ar := packp.NewAdvRefs()
+		err := ar.Decode(bytes.NewReader(content))
+		if nil != err {
+			if packp.ErrEmptyAdvRefs == err {
+				err = transport.ErrEmptyRemoteRepository
+			}
+			return nil, nil, err
+		}
+		transport.FilterUnsupportedCapabilities(ar.Capabilities)
+		return nil, ar, nil
+	}
+
+	reader := bytes.NewReader(content)
+	buf := make([]byte, 65520)
+
+	kind, line, err := readPkt(reader, buf)
+	if nil != err {
+		return nil, nil, err
+	}
+	if pktData == kind && bytes.HasPrefix(line, []byte("# service=")) {
+		// some servers precede the capability advertisement with the service announcement
+		for pktData == kind {
+			kind, _, err = readPkt(reader, buf)
+			if nil != err {
+				return nil, nil, err
+			}
+		}
+		kind, line, err = readPkt(reader, buf)
+		if nil != err {
+			return nil, nil, err
+		}
+	}
+	if pktData != kind || "version 2" != strings.TrimSuffix(string(line), "\n") {
+		return v1()
+	}
+
+	for {
+		kind, line, err = readPkt(reader, buf)
+		if nil != err {
+			return nil, nil, err
+		}
+		if pktData != kind {
+			break
+		}
+		s := strings.TrimSuffix(string(line), "\n")
+		if i := strings.IndexByte(s, '='); -1 != i {
+			c.caps[s[:i]] = s[i+1:]
+		} else {
+			c.caps[s] = ""
+		}
+	}
+
+	if _, ok := c.caps["ls-refs"]; !ok {
+		return nil, nil, nil
+	}
+	if _, ok := c.caps["fetch"]; !ok {
+		return nil, nil, nil
+	}
+
+	return c, nil, nil
+}
+
+func (c *v2Client) setHeaders(req *http.Request) {
+	req.Header.Set("Git-Protocol", "version=2")
+	req.Header.Set("User-Agent", "git/2.0")
+	if "" != c.username || "" != c.password {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+func (c *v2Client) supports(cap string, feature string) bool {
+	v, ok := c.caps[cap]
+	if !ok {
+		return false
+	}
+	for _, f := range strings.Fields(v) {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *v2Client) command(cmd string, args []string) (io.ReadCloser, error) {
+	var body bytes.Buffer
+	writePkt(&body, "command="+cmd+"\n")
+	if _, ok := c.caps["agent"]; ok {
+		writePkt(&body, "agent=git/2.0\n")
+	}
+	body.WriteString("0001")
+	for _, a := range args {
+		writePkt(&body, a+"\n")
+	}
+	body.WriteString("0000")
+
+	req, err := http.NewRequest("POST", c.url+"/git-upload-pack", &body)
+	if nil != err {
+		return nil, err
+	}
+	c.setHeaders(req)
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req.Header.Set("Accept", "application/x-git-upload-pack-result")
+
+	rsp, err := c.httpClient.Do(req)
+	if nil != err {
+		return nil, err
+	}
+
+	if 400 <= rsp.StatusCode {
+		rsp.Body.Close()
+		return nil, httpError(rsp)
+	}
+
+	return rsp.Body, nil
+}
+
+func (c *v2Client) listRefs(prefixes []string) (res map[string]string, err error) {
+	defer trace(prefixes)(&err)
+
+	args := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		args[i] = "ref-prefix " + p
+	}
+
+	body, err := c.command("ls-refs", args)
+	if nil != err {
+		return nil, err
+	}
+	defer body.Close()
+
+	reader := bufio.NewReader(body)
+	buf := make([]byte, 65520)
+
+	res = make(map[string]string)
+	for {
+		kind, line, err := readPkt(reader, buf)
+		if nil != err {
+			return nil, err
+		}
+		if pktData != kind {
+			break
+		}
+		// line format: obj-id SP refname *(SP ref-attribute) LF
+		f := strings.Fields(string(line))
+		if 2 > len(f) {
+			return nil, errors.New("invalid ls-refs response")
+		}
+		if "unborn" == f[0] {
+			continue
+		}
+		res[f[1]] = f[0]
+	}
+
+	return res, nil
+}
+
+func (c *v2Client) objectInfo(wants []string) (res map[string]int64, err error) {
+	defer trace(len(wants))(&err)
+
+	args := make([]string, 0, len(wants)+1)
+	args = append(args, "size")
+	for _, w := range wants {
+		args = append(args, "oid "+w)
+	}
+
+	body, err := c.command("object-info", args)
+	if nil != err {
+		return nil, err
+	}
+	defer body.Close()
+
+	reader := bufio.NewReader(body)
+	buf := make([]byte, 65520)
+
+	// first line lists the returned attributes
+	kind, line, err := readPkt(reader, buf)
+	if nil != err {
+		return nil, err
+	}
+	if pktData != kind || "size" != strings.TrimSuffix(string(line), "\n") {
+		return nil, errors.New("invalid object-info response")
+	}
+
+	res = make(map[string]int64, len(wants))
+	for {
+		kind, line, err := readPkt(reader, buf)
+		if nil != err {
+			return nil, err
+		}
+		if pktData != kind {
+			break
+		}
+		// line format: obj-id SP obj-size LF; missing objects have no size
+		f := strings.Fields(string(line))
+		if 2 != len(f) {
+			continue
+		}
+		size, err := strconv.ParseInt(f[1], 10, 64)
+		if nil != err {
+			continue
+		}
+		res[f[0]] = size
+	}
+
+	return res, nil
+}
+
+// sidebandReader demultiplexes the packfile section of a fetch response.
+type sidebandReader struct {
+	reader io.Reader
+	buf    []byte
+	data   []byte
+	done   bool
+}
+
+func (s *sidebandReader) Read(p []byte) (n int, err error) {
+	for 0 == len(s.data) {
+		if s.done {
+			return 0, io.EOF
+		}
+		kind, line, err := readPkt(s.reader, s.buf)
+		if nil != err {
+			return 0, err
+		}
+		if pktData != kind {
+			s.done = true
+			continue
+		}
+		if 0 == len(line) {
+			continue
+		}
+		switch line[0] {
+		case 1:
+			s.data = line[1:]
+		case 2:
+			// progress
+		case 3:
+			return 0, errors.New("remote: " + strings.TrimSpace(string(line[1:])))
+		default:
+			return 0, errors.New("invalid sideband")
+		}
+	}
+	n = copy(p, s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+func (c *v2Client) fetchPack(wants []string, fn func(reader io.Reader) error) (err error) {
+	defer trace(len(wants))(&err)
+
+	args := []string{"no-progress", "ofs-delta"}
+	if c.supports("fetch", "shallow") {
+		args = append(args, "deepen 1")
+	}
+	if c.supports("fetch", "filter") {
+		args = append(args, "filter tree:0")
+	}
+	for _, w := range wants {
+		args = append(args, "want "+w)
+	}
+	args = append(args, "done")
+
+	body, err := c.command("fetch", args)
+	if nil != err {
+		return err
+	}
+	defer body.Close()
+
+	reader := bufio.NewReader(body)
+	buf := make([]byte, 65520)
+
+	for {
+		kind, line, err := readPkt(reader, buf)
+		if nil != err {
+			return err
+		}
+		if pktData != kind {
+			return errors.New("fetch response has no packfile")
+		}
+		section := strings.TrimSuffix(string(line), "\n")
+		if "packfile" == section {
+			break
+		}
+		// skip other sections (shallow-info, etc.)
+		for pktData == kind {
+			kind, _, err = readPkt(reader, buf)
+			if nil != err {
+				return err
+			}
+		}
+		if pktDelim != kind {
+			return errors.New("fetch response has no packfile")
+		}
+	}
+
+	return fn(&sidebandReader{reader: reader, buf: buf})
+}
@@ -0,0 +1,245 @@
+/*
+ * v2_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const v2BlobContent = "hello, world\n"
+
+func newV2TestServer(t *testing.T) (*httptest.Server, string) {
+	storage := memory.NewStorage()
+	obj := storage.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, _ := obj.Writer()
+	w.Write([]byte(v2BlobContent))
+	w.Close()
+	blob, err := storage.SetEncodedObject(obj)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	refs := map[string]string{
+		"refs/heads/master": blob.String(),
+		"refs/heads/dev":    blob.String(),
+		"refs/tags/v1.0":    blob.String(),
+		"refs/pull/1/head":  blob.String(),
+	}
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		var rsp bytes.Buffer
+		if "version=2" != req.Header.Get("Git-Protocol") {
+			w.WriteHeader(400)
+			return
+		}
+		switch {
+		case "GET" == req.Method && strings.HasSuffix(req.URL.Path, "/info/refs"):
+			writePkt(&rsp, "# service=git-upload-pack\n")
+			rsp.WriteString("0000")
+			writePkt(&rsp, "version 2\n")
+			writePkt(&rsp, "ls-refs\n")
+			writePkt(&rsp, "fetch=shallow\n")
+			writePkt(&rsp, "object-info\n")
+			rsp.WriteString("0000")
+		case "POST" == req.Method && strings.HasSuffix(req.URL.Path, "/git-upload-pack"):
+			reader := bufio.NewReader(req.Body)
+			buf := make([]byte, 65520)
+			command := ""
+			prefixes := []string{}
+			wants := []plumbing.Hash{}
+			for {
+				kind, line, err := readPkt(reader, buf)
+				if nil != err || pktFlush == kind {
+					break
+				}
+				s := strings.TrimSuffix(string(line), "\n")
+				switch {
+				case strings.HasPrefix(s, "command="):
+					command = s[len("command="):]
+				case strings.HasPrefix(s, "ref-prefix "):
+					prefixes = append(prefixes, s[len("ref-prefix "):])
+				case strings.HasPrefix(s, "want "):
+					wants = append(wants, plumbing.NewHash(s[len("want "):]))
+				case strings.HasPrefix(s, "oid "):
+					wants = append(wants, plumbing.NewHash(s[len("oid "):]))
+				}
+			}
+			switch command {
+			case "ls-refs":
+				for n, h := range refs {
+					if hasRefPrefix(n, prefixes) {
+						writePkt(&rsp, h+" "+n+"\n")
+					}
+				}
+				rsp.WriteString("0000")
+			case "object-info":
+				writePkt(&rsp, "size\n")
+				for _, h := range wants {
+					if size, err := storage.EncodedObjectSize(h); nil == err {
+						writePkt(&rsp, fmt.Sprintf("%s %d\n", h, size))
+					} else {
+						writePkt(&rsp, h.String()+"\n")
+					}
+				}
+				rsp.WriteString("0000")
+			case "fetch":
+				var pack bytes.Buffer
+				_, err := packfile.NewEncoder(&pack, storage, false).Encode(wants, 0)
+				if nil != err {
+					w.WriteHeader(500)
+					return
+				}
+				writePkt(&rsp, "packfile\n")
+				for b := pack.Bytes(); 0 < len(b); {
+					n := len(b)
+					if 1000 < n {
+						n = 1000
+					}
+					writePkt(&rsp, "\x01"+string(b[:n]))
+					b = b[n:]
+				}
+				rsp.WriteString("0000")
+			default:
+				w.WriteHeader(400)
+				return
+			}
+		default:
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(rsp.Bytes())
+	}
+
+	return httptest.NewServer(http.HandlerFunc(handler)), blob.String()
+}
+
+func TestV2(t *testing.T) {
+	server, blob := newV2TestServer(t)
+	defer server.Close()
+
+	repository, err := OpenRepository(server.URL+"/owner/repo", "", "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	if nil == repository.v2 {
+		t.Fatal()
+	}
+
+	refs, err := repository.ListRefs([]string{"refs/heads/"})
+	if nil != err {
+		t.Error(err)
+	}
+	if 2 != len(refs) || blob != refs["refs/heads/master"] || blob != refs["refs/heads/dev"] {
+		t.Error(refs)
+	}
+
+	refs, err = repository.ListRefs([]string{"refs/tags/v1.0"})
+	if nil != err {
+		t.Error(err)
+	}
+	if 1 != len(refs) || blob != refs["refs/tags/v1.0"] {
+		t.Error(refs)
+	}
+
+	refs, err = repository.GetRefs()
+	if nil != err {
+		t.Error(err)
+	}
+	if 4 != len(refs) {
+		t.Error(refs)
+	}
+
+	missing := "0123456789012345678901234567890123456789"
+	sizes, err := repository.GetObjectSizes([]string{blob, missing})
+	if nil != err {
+		t.Error(err)
+	}
+	if 1 != len(sizes) || int64(len(v2BlobContent)) != sizes[blob] {
+		t.Error(sizes)
+	}
+
+	found := false
+	err = repository.FetchObjects([]string{blob},
+		func(hash string, ot ObjectType, content []byte) error {
+			if blob == hash && BlobObject == ot && v2BlobContent == string(content) {
+				found = true
+			}
+			return nil
+		})
+	if nil != err {
+		t.Error(err)
+	}
+	if !found {
+		t.Error()
+	}
+}
+
+func TestV2FallbackV1(t *testing.T) {
+	hash := "0123456789012345678901234567890123456789"
+	count := 0
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		var rsp bytes.Buffer
+		if "GET" != req.Method || !strings.HasSuffix(req.URL.Path, "/info/refs") {
+			w.WriteHeader(400)
+			return
+		}
+		count++
+		writePkt(&rsp, "# service=git-upload-pack\n")
+		rsp.WriteString("0000")
+		writePkt(&rsp, hash+" HEAD\x00multi_ack ofs-delta side-band-64k\n")
+		writePkt(&rsp, hash+" refs/heads/master\n")
+		writePkt(&rsp, hash+" refs/tags/v1.0\n")
+		rsp.WriteString("0000")
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		w.Write(rsp.Bytes())
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	repository, err := OpenRepository(server.URL+"/owner/repo", "", "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	if nil != repository.v2 {
+		t.Error()
+	}
+
+	refs, err := repository.ListRefs([]string{"refs/heads/"})
+	if nil != err {
+		t.Error(err)
+	}
+	if 1 != len(refs) || hash != refs["refs/heads/master"] {
+		t.Error(refs)
+	}
+
+	// the reference advertisement of the version 2 probe is used for version 1
+	if 1 != count {
+		t.Error(count)
+	}
+}
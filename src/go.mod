@@ -6,7 +6,9 @@ require (
 	github.com/billziss-gh/golib v0.2.0
 	github.com/cli/browser v1.0.0
 	github.com/cli/oauth v0.9.0
+	github.com/go-git/go-billy/v5 v5.0.0
 	github.com/go-git/go-git/v5 v5.2.0
+	github.com/sergi/go-diff v1.1.0
 	github.com/winfsp/cgofuse v1.6.0
 )
 
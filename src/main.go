@@ -23,6 +23,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/billziss-gh/golib/keyring"
@@ -45,6 +46,15 @@ var (
 
 var progname = strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
 
+/* commands that do not mount; invoked as: progname command [args] */
+type command struct {
+	run  func(args []string) int
+	args string
+	help string
+}
+
+var commands = map[string]command{}
+
 func warn(format string, a ...interface{}) {
 	format = "%s: " + format + "\n"
 	a = append([]interface{}{progname}, a...)
@@ -68,7 +78,8 @@ func oauthNewClientWithKey(provider prov.Provider, authkey string) (
 	token, err := provider.Auth()
 	if nil == err {
 		client, err = provider.NewClient(token)
-		if nil == err {
+		if nil == err && "" != token {
+			/* providers without auth (e.g. generic, local) return an empty token */
 			keyring.Set(MyProductName, authkey, token)
 		}
 	}
@@ -97,6 +108,67 @@ func gitauthNewClientWithUri(provider prov.Provider, uri *url.URL) (
 	return
 }
 
+/* newClient creates a client for the provider of remote using the auth method */
+func newClient(remote string, authmeth string, authkey string) (
+	uri *url.URL, provider prov.Provider, client prov.Client, ok bool) {
+	var err error
+	implicit := false
+	uri, err = prov.ParseRemote(remote)
+	if nil != uri && "" == uri.Scheme {
+		uri, err = url.Parse("https://" + remote)
+		implicit = true
+	}
+	if nil != err {
+		warn("invalid remote: %s", remote)
+		return
+	}
+
+	provider = prov.NewProviderInstance(uri)
+	if implicit && prov.GetProviderInstanceName(uri) != uri.Host {
+		/* a remote without scheme must name a known host */
+		provider = nil
+	}
+	if nil == provider {
+		warn("unknown provider: %s", prov.GetProviderInstanceName(uri))
+		return
+	}
+
+	if "" == authkey {
+		authkey = prov.GetProviderInstanceName(uri)
+	}
+
+	switch authmeth {
+	case "force":
+		client, err = oauthNewClientWithKey(provider, authkey)
+	case "full":
+		client, err = newClientWithKey(provider, authkey)
+		if nil != err {
+			client, err = oauthNewClientWithKey(provider, authkey)
+		}
+	case "required":
+		client, err = newClientWithKey(provider, authkey)
+	case "optional":
+		client, err = newClientWithKey(provider, authkey)
+		if nil != err {
+			client, err = provider.NewClient("")
+		}
+	case "none":
+		client, err = provider.NewClient("")
+	case "git":
+		client, err = gitauthNewClientWithUri(provider, uri)
+	default:
+		if strings.HasPrefix(authmeth, "token=") {
+			client, err = provider.NewClient(strings.TrimPrefix(authmeth, "token="))
+		}
+	}
+	if nil != err {
+		warn("client error: %v", err)
+		return
+	}
+
+	return uri, provider, client, true
+}
+
 func mount(client prov.Client, overlay bool, prefix string, mntpnt string, config []string) bool {
 	mntopt := []string{}
 	for _, s := range config {
@@ -146,15 +218,30 @@ func run() int {
 	authonly := false
 	readonly := false
 	fullrefs := false
+	offline := false
 	filter := util.Optlist{}
+	provspec := util.Optlist{}
 	mntopt := util.Optlist{}
 	remote := "github.com"
 	mntpnt := ""
 	config := []string{"config.dir=:"}
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [options] [remote] mountpoint\n\n", progname)
+		fmt.Fprintf(os.Stderr, "usage: %s [options] [--] [remote] mountpoint\n", progname)
+		names := []string{}
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(os.Stderr, "       %s %s %s\n", progname, n, commands[n].args)
+		}
+		fmt.Fprintf(os.Stderr, "\n")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\ncommands:\n")
+		for _, n := range names {
+			fmt.Fprintf(os.Stderr, "  %-10s%s\n", n, commands[n].help)
+		}
 		fmt.Fprintf(os.Stderr, "\nremotes:\n")
 		for _, n := range prov.GetProviderClassNames() {
 			fmt.Fprintf(os.Stderr, "  %s\n", prov.GetProviderClassHelp(n))
@@ -176,18 +263,40 @@ func run() int {
 	flag.BoolVar(&authonly, "authonly", authonly, "perform auth only; do not mount")
 	flag.BoolVar(&readonly, "readonly", readonly, "read only file system")
 	flag.BoolVar(&fullrefs, "fullrefs", fullrefs, "full format refs (refs+heads+master instead of master)")
+	flag.BoolVar(&offline, "offline", offline, "offline mode; use only previously cached content\n"+
+		"(requires -o config.dir=DIR or -o config.objdir=DIR)")
 	flag.Var(&filter, "filter",
 		"list of `rules` that determine repo availability\n"+
 			"- list form: rule1,rule2,...\n"+
 			"- rule form: [+-]owner or [+-]owner/repo\n"+
 			"- rule is include (+) or exclude (-) (default: include)\n"+
 			"- rule owner/repo can use wildcards for pattern matching")
+	flag.Var(&provspec, "provider",
+		"register provider for additional host using `spec`\n"+
+			"- spec form: host=kind[,key=value...] or @file (one spec per line)\n"+
+			"- kind is one of: "+strings.Join(prov.GetProviderKindNames(), ", ")+"\n"+
+			"- key is one of: api, clientid, clientsecret, callback, scopes\n"+
+			"- example: ghe.corp.example=github,api=https://ghe.corp.example/api/v3")
 	flag.Var(&mntopt, "o", "FUSE mount `options`\n(default: "+strings.Join(default_mntopt, ",")+")")
 
 	util.InvokeEvent("main.Flagvar", nil)
 
 	flag.Parse()
 
+	/*
+	 * A command is the first argument after the options, unless it follows "--" (which
+	 * allows a mountpoint with the name of a command). The options are passed to it.
+	 */
+	if 0 < flag.NArg() {
+		if cmd, ok := commands[flag.Arg(0)]; ok {
+			i := len(os.Args) - flag.NArg()
+			if "--" != os.Args[i-1] {
+				args := append(append([]string{}, os.Args[1:i]...), os.Args[i+1:]...)
+				return cmd.run(args)
+			}
+		}
+	}
+
 	if printver {
 		name := MyProductName
 		if "" != MyProductTag {
@@ -232,6 +341,15 @@ func run() int {
 		return 2
 	}
 
+	if offline {
+		if authonly {
+			flag.Usage()
+			return 2
+		}
+		/* offline: do not contact the provider for auth */
+		authmeth = "none"
+	}
+
 	if debug {
 		libtrace.Verbose = true
 		libtrace.Pattern = "*,github.com/winfsp/hubfs/*,github.com/winfsp/hubfs/fs/*"
@@ -239,54 +357,22 @@ func run() int {
 
 	util.InvokeEvent("main.Flagrun", nil)
 
-	uri, err := url.Parse(remote)
-	if nil != uri && "" == uri.Scheme {
-		uri, err = url.Parse("https://" + remote)
-	}
-	if nil != err {
-		warn("invalid remote: %s", remote)
-		return 1
-	}
-
-	provider := prov.NewProviderInstance(uri)
-	if nil == provider {
-		warn("unknown provider: %s", prov.GetProviderInstanceName(uri))
-		return 1
-	}
-
-	if "" == authkey {
-		authkey = prov.GetProviderInstanceName(uri)
-	}
-
-	var client prov.Client
-	switch authmeth {
-	case "force":
-		client, err = oauthNewClientWithKey(provider, authkey)
-	case "full":
-		client, err = newClientWithKey(provider, authkey)
-		if nil != err {
-			client, err = oauthNewClientWithKey(provider, authkey)
-		}
-	case "required":
-		client, err = newClientWithKey(provider, authkey)
-	case "optional":
-		client, err = newClientWithKey(provider, authkey)
+	for _, s := range provspec {
+		err := prov.RegisterProviderInstanceSpec(s)
 		if nil != err {
-			client, err = provider.NewClient("")
-		}
-	case "none":
-		client, err = provider.NewClient("")
-	case "git":
-		client, err = gitauthNewClientWithUri(provider, uri)
-	default:
-		if strings.HasPrefix(authmeth, "token=") {
-			client, err = provider.NewClient(strings.TrimPrefix(authmeth, "token="))
+			warn("provider error: %v", err)
+			return 1
 		}
 	}
-	if nil != err {
-		warn("client error: %v", err)
+
+	uri, provider, client, ok := newClient(remote, authmeth, authkey)
+	if !ok {
 		return 1
 	}
+	if "ssh" == uri.Scheme {
+		/* ssh remote: clone repositories over SSH */
+		config = append(config, "config.ssh=1")
+	}
 
 	if !authonly {
 		if 0 == len(mntopt) {
@@ -318,21 +404,35 @@ func run() int {
 			config = append(config, "config._fullrefs=1")
 		}
 
+		if offline {
+			config = append(config, "config.offline=1")
+		}
+
 		for _, f := range filter {
 			for _, s := range strings.Split(f, ",") {
 				config = append(config, "config._filter="+s)
 			}
 		}
 
+		var err error
 		config, err = client.SetConfig(config)
 		if nil != err {
 			warn("config error: %v", err)
 			return 1
 		}
 
+		prefix := strings.TrimSuffix(uri.Path, ".git")
+		if "" != prefix && !strings.HasPrefix(prefix, "/") {
+			/* scp-like remote (e.g. git@github.com:owner/repo) */
+			prefix = "/" + prefix
+		}
+		if p, ok := provider.(prov.PrefixProvider); ok {
+			prefix = p.Prefix()
+		}
+
 		port.Umask(0)
 
-		if !mount(client, !readonly, uri.Path, mntpnt, config) {
+		if !mount(client, !readonly, prefix, mntpnt, config) {
 			return 1
 		}
 	}
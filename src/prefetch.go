@@ -0,0 +1,89 @@
+/*
+ * prefetch.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/winfsp/hubfs/prov"
+)
+
+func init() {
+	commands["prefetch"] = command{prefetch, "[options] [remote] owner/repo@ref[:path]",
+		"fetch the content of a ref into the cache without mounting"}
+}
+
+func prefetch(args []string) int {
+	var refopt refOptions
+	jobs := 8
+	verbose := false
+	remote := "github.com"
+	spec := ""
+
+	flags := flag.NewFlagSet(progname+" prefetch", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s prefetch %s\n\n", progname, commands["prefetch"].args)
+		flags.PrintDefaults()
+	}
+	refopt.addFlags(flags)
+	flags.IntVar(&jobs, "j", jobs, "`number` of concurrent fetch requests (each fetches a batch of files)")
+	flags.BoolVar(&verbose, "v", verbose, "print each file as it is fetched")
+	if nil != flags.Parse(args) {
+		return 2
+	}
+
+	switch flags.NArg() {
+	case 1:
+		spec = flags.Arg(0)
+	case 2:
+		remote = flags.Arg(0)
+		spec = flags.Arg(1)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if _, _, _, _, ok := parseRefSpec(spec); !ok {
+		flags.Usage()
+		return 2
+	}
+
+	rs, ok := refopt.openRefSpec(remote, spec)
+	if !ok {
+		return 1
+	}
+	defer rs.close()
+
+	var count, total int64
+	err := prov.PrefetchTree(rs.repository, rs.ref, rs.path, jobs, func(pathname string, size int64, err error) {
+		if nil != err {
+			warn("%s: %v", pathname, err)
+			return
+		}
+		atomic.AddInt64(&count, 1)
+		atomic.AddInt64(&total, size)
+		if verbose {
+			fmt.Println(pathname)
+		}
+	})
+	fmt.Printf("%s: %d files (%d bytes) in %s\n", spec, count, total, rs.client.GetDirectory())
+	if nil != err {
+		warn("prefetch error: %v", err)
+		return 1
+	}
+
+	return 0
+}
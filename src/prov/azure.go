@@ -0,0 +1,244 @@
+/*
+ * azure.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/winfsp/hubfs/httputil"
+)
+
+type AzureProvider struct {
+	Hostname string
+	ApiURI   string
+}
+
+func NewAzureComProvider(uri *url.URL) Provider {
+	return newAzureProvider("dev.azure.com", nil)
+}
+
+func newAzureProvider(hostname string, config map[string]string) Provider {
+	return &AzureProvider{
+		Hostname: hostname,
+		ApiURI:   providerConfigValue(config, "api", "https://"+hostname),
+	}
+}
+
+func init() {
+	RegisterProviderClass("dev.azure.com", NewAzureComProvider, ""+
+		"[https://]dev.azure.com[/owner[/repo]]\n"+
+		"    \taccess dev.azure.com (Azure DevOps Repos)\n"+
+		"    \t- owner     file system root is at owner (org or org+project)\n"+
+		"    \t- repo      file system root is at owner/repo (project+repo or repo)\n"+
+		"    \t- personal access token auth: -auth token=PAT")
+	RegisterProviderKind("azure", newAzureProvider)
+}
+
+func (p *AzureProvider) Auth() (token string, err error) {
+	return "", errors.New(
+		p.Hostname + ": interactive auth not supported; use -auth token=PAT with a personal access token")
+}
+
+func (p *AzureProvider) NewClient(token string) (Client, error) {
+	return NewAzureClient(p.ApiURI, token)
+}
+
+type azureClient struct {
+	client
+	httpClient *http.Client
+	ident      string
+	apiURI     string
+	token      string
+}
+
+// NewAzureClient creates a client for the Azure DevOps API. The token is a personal
+// access token (PAT) and is sent using basic auth.
+func NewAzureClient(apiURI string, token string) (Client, error) {
+	uri, err := url.Parse(apiURI)
+	if nil != err {
+		return nil, err
+	}
+
+	c := &azureClient{
+		httpClient: httputil.DefaultClient,
+		ident:      uri.Hostname(),
+		apiURI:     strings.TrimSuffix(apiURI, "/"),
+		token:      token,
+	}
+	c.client.init(c)
+
+	return c, nil
+}
+
+func (c *azureClient) getIdent() string {
+	return c.ident
+}
+
+func (c *azureClient) getGitCredentials() (string, string) {
+	if "" == c.token {
+		return "", ""
+	}
+	return "pat", c.token
+}
+
+func (c *azureClient) sendrecv(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.apiURI+path, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if "" != c.token {
+		req.SetBasicAuth("", c.token)
+	}
+
+	rsp, err := c.httpClient.Do(req)
+	if nil != err {
+		return nil, err
+	}
+
+	if 404 == rsp.StatusCode {
+		rsp.Body.Close()
+		return nil, ErrNotFound
+	} else if 400 <= rsp.StatusCode {
+		rsp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+	} else if 203 == rsp.StatusCode {
+		// Azure DevOps answers unauthenticated API requests with a sign-in page
+		rsp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+	}
+
+	return rsp, nil
+}
+
+// splitOwner splits an owner name into organization and (optional) project.
+func (c *azureClient) splitOwner(o string) (org string, project string) {
+	if i := strings.IndexRune(o, AltPathSeparator); -1 != i {
+		return o[:i], o[i+1:]
+	}
+	return o, ""
+}
+
+func (c *azureClient) getOwner(o string) (res *owner, err error) {
+	defer trace(o)(&err)
+
+	org, project := c.splitOwner(o)
+	if "" == org {
+		return nil, ErrNotFound
+	}
+
+	var path string
+	if "" == project {
+		path = fmt.Sprintf("/%s/_apis/projects?$top=1&api-version=6.0", url.PathEscape(org))
+	} else {
+		path = fmt.Sprintf("/%s/_apis/projects/%s?api-version=6.0",
+			url.PathEscape(org), url.PathEscape(project))
+	}
+
+	rsp, err := c.sendrecv(path)
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		FName string `json:"name"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = &owner{
+		FName: org,
+		FKind: "organization",
+	}
+	if "" != project {
+		res.FName = org + string(AltPathSeparator) + content.FName
+		res.FKind = "project"
+	}
+	res.Value = res
+	return
+}
+
+func (c *azureClient) getRepositories(owner string, kind string) (res []*repository, err error) {
+	defer trace(owner)(&err)
+
+	org, project := c.splitOwner(owner)
+
+	var path string
+	if "project" == kind {
+		path = fmt.Sprintf("/%s/%s/_apis/git/repositories?api-version=6.0",
+			url.PathEscape(org), url.PathEscape(project))
+	} else {
+		path = fmt.Sprintf("/%s/_apis/git/repositories?api-version=6.0", url.PathEscape(org))
+	}
+
+	rsp, err := c.sendrecv(path)
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Value []struct {
+			FName      string `json:"name"`
+			FRemote    string `json:"remoteUrl"`
+			FSshRemote string `json:"sshUrl"`
+			IsDisabled bool   `json:"isDisabled"`
+			Project    struct {
+				FName string `json:"name"`
+			} `json:"project"`
+		} `json:"value"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]*repository, 0, len(content.Value))
+	for _, elm := range content.Value {
+		if elm.IsDisabled {
+			continue
+		}
+		n := elm.FName
+		if "project" != kind {
+			n = elm.Project.FName + "/" + n
+		}
+		n = strings.ReplaceAll(n, "/", string(AltPathSeparator))
+		remote := elm.FRemote
+		if u, e := url.Parse(remote); nil == e {
+			// credentials are supplied by getGitCredentials
+			u.User = nil
+			remote = u.String()
+		}
+		r := &repository{
+			FName:      n,
+			FRemote:    remote,
+			FSshRemote: elm.FSshRemote,
+		}
+		r.Value = r
+		r.Repository = emptyRepository
+		r.keepdir = c.keepdir
+		res = append(res, r)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,116 @@
+/*
+ * azure_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newAzureTestServer(t *testing.T) *httptest.Server {
+	repo := func(project string, name string, disabled bool) string {
+		return fmt.Sprintf(`{"name":%q,`+
+			`"remoteUrl":"https://org@dev.azure.example/org/%s/_git/%s",`+
+			`"sshUrl":"git@ssh.dev.azure.example:v3/org/%s/%s",`+
+			`"isDisabled":%v,"project":{"name":%q}}`,
+			name, project, name, project, name, disabled, project)
+	}
+
+	auth := func(w http.ResponseWriter, req *http.Request) bool {
+		if _, password, ok := req.BasicAuth(); !ok || "pat" != password {
+			/* unauthenticated requests are answered with a sign-in page */
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(203)
+			fmt.Fprint(w, "<html>Sign in</html>")
+			return false
+		}
+		if "6.0" != req.URL.Query().Get("api-version") {
+			w.WriteHeader(400)
+			return false
+		}
+		return true
+	}
+
+	return newTestApiServer(auth, map[string]http.HandlerFunc{
+		"/org/_apis/projects":      testReply(`{"count":1,"value":[{"name":"Proj"}]}`),
+		"/org/_apis/projects/proj": testReply(`{"name":"Proj"}`),
+		"/org/_apis/git/repositories": testReply(fmt.Sprintf(`{"count":3,"value":[%s,%s,%s]}`,
+			repo("Proj", "repo1", false), repo("Proj", "repo2", true), repo("Other", "repo3", false))),
+		"/org/Proj/_apis/git/repositories": testReply(fmt.Sprintf(`{"count":2,"value":[%s,%s]}`,
+			repo("Proj", "repo1", false), repo("Proj", "repo2", true))),
+	})
+}
+
+func TestAzureClient(t *testing.T) {
+	server := newAzureTestServer(t)
+	defer server.Close()
+
+	anon, err := NewAzureClient(server.URL, "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, err = anon.OpenOwner("org")
+	if nil == err || ErrNotFound == err {
+		t.Error(err)
+	}
+
+	c, err := NewAzureClient(server.URL+"/", "pat")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	expect := func(name string, ename string, erepos []string) {
+		owner, err := c.OpenOwner(name)
+		if nil != err {
+			t.Fatal(err)
+		}
+		defer c.CloseOwner(owner)
+		if ename != owner.Name() {
+			t.Error(owner.Name())
+		}
+		repositories, err := c.GetRepositories(owner)
+		if nil != err {
+			t.Error(err)
+		}
+		if len(erepos) != len(repositories) {
+			t.Fatalf("owner %q expect %v got %d repositories", name, erepos, len(repositories))
+		}
+		names := make(map[string]string)
+		for _, r := range repositories {
+			names[r.Name()] = r.(*repository).FRemote
+		}
+		for _, n := range erepos {
+			if remote, ok := names[n]; !ok {
+				t.Errorf("owner %q missing repository %q", name, n)
+			} else if !strings.HasPrefix(remote, "https://dev.azure.example/org/") {
+				t.Error(remote)
+			}
+		}
+	}
+
+	expect("org", "org", []string{"Proj+repo1", "Other+repo3"})
+	expect("org+proj", "org+Proj", []string{"repo1"})
+
+	_, err = c.OpenOwner("nonexistent")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+	_, err = c.OpenOwner("org+nonexistent")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+}
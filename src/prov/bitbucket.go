@@ -0,0 +1,340 @@
+/*
+ * bitbucket.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cli/oauth"
+	"github.com/winfsp/hubfs/httputil"
+)
+
+type BitbucketProvider struct {
+	Hostname     string
+	ClientId     string
+	ClientSecret string
+	CallbackURI  string
+	Scopes       string
+	ApiURI       string
+}
+
+func NewBitbucketOrgProvider(uri *url.URL) Provider {
+	return newBitbucketProvider("bitbucket.org", nil)
+}
+
+func newBitbucketProvider(hostname string, config map[string]string) Provider {
+	return &BitbucketProvider{
+		Hostname:     hostname,
+		ClientId:     providerConfigValue(config, "clientid", ""),
+		ClientSecret: providerConfigValue(config, "clientsecret", ""),
+		CallbackURI:  providerConfigValue(config, "callback", "http://127.0.0.1/callback"),
+		Scopes:       providerConfigValue(config, "scopes", "account,repository"),
+		ApiURI:       providerConfigValue(config, "api", "https://api."+hostname+"/2.0"),
+	}
+}
+
+func init() {
+	RegisterProviderClass("bitbucket.org", NewBitbucketOrgProvider, ""+
+		"[https://]bitbucket.org[/owner[/repo]]\n"+
+		"    \taccess bitbucket.org\n"+
+		"    \t- owner     file system root is at owner (workspace, user account ID or {UUID})\n"+
+		"    \t- repo      file system root is at owner/repo\n"+
+		"    \t- auth      use -auth token=USERNAME:APPPASSWORD or -auth token=ACCESSTOKEN;\n"+
+		"    \t            interactive auth requires an OAuth consumer, e.g.\n"+
+		"    \t            -provider bitbucket.org=bitbucket,clientid=KEY,clientsecret=SECRET")
+	RegisterProviderKind("bitbucket", newBitbucketProvider)
+}
+
+func (p *BitbucketProvider) Auth() (token string, err error) {
+	if "" == p.ClientId {
+		return "", errors.New(p.Hostname + ": no OAuth consumer configured; " +
+			"use -auth token=USERNAME:APPPASSWORD or -auth token=ACCESSTOKEN")
+	}
+
+	flow := &oauth.Flow{
+		Host: &oauth.Host{
+			AuthorizeURL: fmt.Sprintf("https://%s/site/oauth2/authorize", p.Hostname),
+			TokenURL:     fmt.Sprintf("https://%s/site/oauth2/access_token", p.Hostname),
+		},
+		ClientID:     p.ClientId,
+		ClientSecret: p.ClientSecret,
+		CallbackURI:  p.CallbackURI,
+		Scopes:       strings.Split(p.Scopes, ","),
+		HTTPClient:   httputil.DefaultClient,
+	}
+	accessToken, err := flow.WebAppFlow()
+	if nil != accessToken {
+		token = accessToken.Token
+	}
+	return
+}
+
+func (p *BitbucketProvider) NewClient(token string) (Client, error) {
+	return NewBitbucketClient(p.ApiURI, token)
+}
+
+type bitbucketClient struct {
+	client
+	httpClient *http.Client
+	ident      string
+	apiURI     string
+	username   string
+	password   string
+	token      string
+}
+
+// NewBitbucketClient creates a client for the Bitbucket Cloud API. The token may be an
+// OAuth access token or an app password specified as USERNAME:APPPASSWORD.
+func NewBitbucketClient(apiURI string, token string) (Client, error) {
+	uri, err := url.Parse(apiURI)
+	if nil != err {
+		return nil, err
+	}
+
+	ident := uri.Hostname()
+	if strings.HasPrefix(ident, "api.") {
+		ident = ident[len("api."):]
+	}
+
+	c := &bitbucketClient{
+		httpClient: httputil.DefaultClient,
+		ident:      ident,
+		apiURI:     apiURI,
+	}
+	if i := strings.IndexByte(token, ':'); -1 != i {
+		c.username = token[:i]
+		c.password = token[i+1:]
+	} else {
+		c.token = token
+	}
+	c.client.init(c)
+
+	if "" != c.token || "" != c.password {
+		// verify the credentials
+		rsp, err := c.sendrecv(c.apiURI + "/user")
+		if nil != err {
+			return nil, err
+		}
+		rsp.Body.Close()
+	}
+
+	return c, nil
+}
+
+func (c *bitbucketClient) getIdent() string {
+	return c.ident
+}
+
+func (c *bitbucketClient) getGitCredentials() (string, string) {
+	if "" != c.password {
+		return c.username, c.password
+	}
+	if "" != c.token {
+		return "x-token-auth", c.token
+	}
+	return "", ""
+}
+
+func (c *bitbucketClient) sendrecv(uri string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if "" != c.password {
+		req.SetBasicAuth(c.username, c.password)
+	} else if "" != c.token {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	rsp, err := c.httpClient.Do(req)
+	if nil != err {
+		return nil, err
+	}
+
+	if 404 == rsp.StatusCode {
+		rsp.Body.Close()
+		return nil, ErrNotFound
+	} else if 400 <= rsp.StatusCode {
+		rsp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+	}
+
+	return rsp, nil
+}
+
+func (c *bitbucketClient) getWorkspace(o string) (res *owner, err error) {
+	defer trace(o)(&err)
+
+	rsp, err := c.sendrecv(c.apiURI + fmt.Sprintf("/workspaces/%s", url.PathEscape(o)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		FName string `json:"slug"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = &owner{
+		FName: content.FName,
+		FKind: "workspace",
+	}
+	res.Value = res
+	return
+}
+
+// getUser gets a user by account ID or {UUID}; Bitbucket no longer looks up users by
+// username (use the workspace of the user instead).
+func (c *bitbucketClient) getUser(o string) (res *owner, err error) {
+	defer trace(o)(&err)
+
+	uuid, err := c.getUserUuid(o)
+	if nil != err {
+		return nil, err
+	}
+	if "" == uuid {
+		return nil, ErrNotFound
+	}
+
+	res = &owner{
+		FName: o,
+		FKind: "user",
+	}
+	res.Value = res
+	return
+}
+
+func (c *bitbucketClient) getUserUuid(o string) (string, error) {
+	rsp, err := c.sendrecv(c.apiURI + fmt.Sprintf("/users/%s", url.PathEscape(o)))
+	if nil != err {
+		return "", err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Uuid string `json:"uuid"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return "", err
+	}
+
+	return content.Uuid, nil
+}
+
+func (c *bitbucketClient) getOwner(o string) (res *owner, err error) {
+	res, err = c.getWorkspace(o)
+	if ErrNotFound != err {
+		return
+	}
+	res, err = c.getUser(o)
+	return
+}
+
+func (c *bitbucketClient) getRepositoryPage(uri string) ([]*repository, string, error) {
+	rsp, err := c.sendrecv(uri)
+	if nil != err {
+		return nil, "", err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Values []struct {
+			FName string `json:"slug"`
+			Links struct {
+				Clone []struct {
+					Name string `json:"name"`
+					Href string `json:"href"`
+				} `json:"clone"`
+			} `json:"links"`
+		} `json:"values"`
+		Next string `json:"next"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, "", err
+	}
+
+	res := make([]*repository, 0, len(content.Values))
+	for _, elm := range content.Values {
+		remote, sshremote := "", ""
+		for _, l := range elm.Links.Clone {
+			switch l.Name {
+			case "https":
+				remote = l.Href
+			case "ssh":
+				sshremote = l.Href
+			}
+		}
+		if "" == remote {
+			continue
+		}
+		if u, e := url.Parse(remote); nil == e {
+			// credentials are supplied by getGitCredentials
+			u.User = nil
+			remote = u.String()
+		}
+		r := &repository{
+			FName:      elm.FName,
+			FRemote:    remote,
+			FSshRemote: sshremote,
+		}
+		r.Value = r
+		r.Repository = emptyRepository
+		r.keepdir = c.keepdir
+		res = append(res, r)
+	}
+
+	return res, content.Next, nil
+}
+
+func (c *bitbucketClient) getRepositories(owner string, kind string) (res []*repository, err error) {
+	defer trace(owner)(&err)
+
+	// the repositories of a user are listed by UUID (the account ID is not accepted)
+	if "user" == kind && !strings.HasPrefix(owner, "{") {
+		owner, err = c.getUserUuid(owner)
+		if nil != err {
+			return nil, err
+		}
+		if "" == owner {
+			return nil, ErrNotFound
+		}
+	}
+
+	uri := c.apiURI + fmt.Sprintf("/repositories/%s?pagelen=100", url.PathEscape(owner))
+
+	res = make([]*repository, 0)
+	for "" != uri {
+		var lst []*repository
+		lst, uri, err = c.getRepositoryPage(uri)
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+	}
+
+	return res, nil
+}
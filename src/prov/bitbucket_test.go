@@ -0,0 +1,127 @@
+/*
+ * bitbucket_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newBitbucketTestServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	repo := func(name string) string {
+		return fmt.Sprintf(`{"slug":%q,"links":{"clone":[`+
+			`{"name":"https","href":"https://user@bitbucket.example/team/%s.git"},`+
+			`{"name":"ssh","href":"git@bitbucket.example:team/%s.git"}]}}`, name, name, name)
+	}
+
+	auth := func(w http.ResponseWriter, req *http.Request) bool {
+		if username, password, ok := req.BasicAuth(); ok {
+			if "user" == username && "apppassword" == password {
+				return true
+			}
+		} else if "Bearer accesstoken" == req.Header.Get("Authorization") {
+			return true
+		}
+		w.WriteHeader(401)
+		return false
+	}
+
+	user := testReply(`{"account_id":"557058:0001","uuid":"{0001}"}`)
+	server = newTestApiServer(auth, map[string]http.HandlerFunc{
+		"/2.0/user":              testReply(`{"account_id":"557058:0001","uuid":"{0001}","nickname":"user"}`),
+		"/2.0/workspaces/team":   testReply(`{"slug":"team"}`),
+		"/2.0/workspaces/broken": testStatus(500),
+		"/2.0/users/557058:0001": user,
+		"/2.0/users/{0001}":      user,
+		"/2.0/repositories/team": func(w http.ResponseWriter, req *http.Request) {
+			if "2" == req.URL.Query().Get("page") {
+				fmt.Fprintf(w, `{"values":[%s]}`, repo("repo3"))
+			} else {
+				fmt.Fprintf(w, `{"values":[%s,%s],"next":"%s/2.0/repositories/team?page=2"}`,
+					repo("repo1"), repo("repo2"), server.URL)
+			}
+		},
+		"/2.0/repositories/{0001}": testReply(fmt.Sprintf(`{"values":[%s]}`, repo("personal"))),
+	})
+	return server
+}
+
+func TestBitbucketClient(t *testing.T) {
+	server := newBitbucketTestServer(t)
+	defer server.Close()
+
+	_, err := NewBitbucketClient(server.URL+"/2.0", "user:wrongpassword")
+	if nil == err {
+		t.Error()
+	}
+
+	_, err = NewBitbucketClient(server.URL+"/2.0", "accesstoken")
+	if nil != err {
+		t.Error(err)
+	}
+
+	c, err := NewBitbucketClient(server.URL+"/2.0", "user:apppassword")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	owner, err := c.OpenOwner("team")
+	if nil != err {
+		t.Fatal(err)
+	}
+	repositories, err := c.GetRepositories(owner)
+	if nil != err {
+		t.Error(err)
+	}
+	if 3 != len(repositories) {
+		t.Error(len(repositories))
+	}
+	for _, r := range repositories {
+		if "https://bitbucket.example/team/"+r.Name()+".git" != r.(*repository).FRemote {
+			t.Error(r.(*repository).FRemote)
+		}
+	}
+	c.CloseOwner(owner)
+
+	for _, name := range []string{"557058:0001", "{0001}"} {
+		owner, err = c.OpenOwner(name)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if name != owner.Name() {
+			t.Error(owner.Name())
+		}
+		repositories, err = c.GetRepositories(owner)
+		if nil != err {
+			t.Error(err)
+		}
+		if 1 != len(repositories) || "personal" != repositories[0].Name() {
+			t.Error()
+		}
+		c.CloseOwner(owner)
+	}
+
+	_, err = c.OpenOwner("nonexistent")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+
+	_, err = c.OpenOwner("broken")
+	if nil == err || ErrNotFound == err {
+		t.Error(err)
+	}
+}
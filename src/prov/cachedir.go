@@ -0,0 +1,266 @@
+/*
+ * cachedir.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A cache directory contains a directory per repository (owner/repo) and its metadata
+// directory .meta. The default cache location contains a cache directory per provider
+// identity (e.g. github.com), which is removed at unmount, and the metadata directory
+// .meta/ident, which is used instead when the object store is persistent (config.objdir).
+// Metadata may therefore outlive the cache directory of its repository; such orphaned
+// metadata is listed and removed like a repository cache. Directories are removed by
+// renaming them with a timestamp suffix and then deleting them; an interrupted removal
+// leaves the renamed directory behind.
+
+// CacheRepository describes the cache directory of a repository.
+type CacheRepository struct {
+	Ident  string   // provider identity (empty for an explicitly configured cache directory)
+	Owner  string   // owner name
+	Name   string   // repository name
+	Path   string   // repository cache directory (metadata directory if orphaned)
+	Size   int64    // total size of files in the repository cache directory
+	Keep   []string // refs with overlays that are kept across mounts
+	Orphan bool     // only metadata is left; the repository cache directory does not exist
+}
+
+var staleRe = regexp.MustCompile(`\.[0-9]{8}T[0-9]{6}\.[0-9]{3}Z$`)
+
+type cacheDir struct {
+	ident    string
+	dir      string
+	metadirs []string
+}
+
+// cacheDirs returns the cache directories under root. If idents is true root is the
+// default cache location; otherwise it is an explicitly configured cache directory. A
+// provider identity is included if it has a cache directory or metadata.
+func cacheDirs(root string, idents bool) (res []cacheDir, err error) {
+	if !idents {
+		_, err = os.Stat(root)
+		if nil != err {
+			return
+		}
+		return []cacheDir{{"", root, []string{filepath.Join(root, ".meta")}}}, nil
+	}
+
+	names, err := readDirNames(root)
+	if nil != err {
+		return
+	}
+	metanames, _ := readDirNames(filepath.Join(root, ".meta"))
+	names = mergeNames(names, metanames)
+	for _, n := range names {
+		res = append(res, cacheDir{n, filepath.Join(root, n), []string{
+			filepath.Join(root, n, ".meta"),
+			filepath.Join(root, ".meta", n),
+		}})
+	}
+	return
+}
+
+// mergeNames merges sorted lists of names.
+func mergeNames(a []string, b []string) (res []string) {
+	seen := make(map[string]bool, len(a)+len(b))
+	for _, n := range append(append([]string{}, a...), b...) {
+		if !seen[n] {
+			seen[n] = true
+			res = append(res, n)
+		}
+	}
+	sort.Strings(res)
+	return
+}
+
+// readDirNames returns the sorted names of the subdirectories of dir, excluding hidden
+// and stale directories.
+func readDirNames(dir string) (res []string, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if nil != err {
+		return
+	}
+	for _, info := range infos {
+		n := info.Name()
+		if info.IsDir() && !strings.HasPrefix(n, ".") && !staleRe.MatchString(n) {
+			res = append(res, n)
+		}
+	}
+	sort.Strings(res)
+	return
+}
+
+// ListCache lists the repository cache directories under root. If idents is true root is
+// the default cache location; otherwise it is an explicitly configured cache directory.
+func ListCache(root string, idents bool) (res []*CacheRepository, err error) {
+	dirs, err := cacheDirs(root, idents)
+	if nil != err {
+		return
+	}
+
+	for _, d := range dirs {
+		owners, _ := readDirNames(d.dir)
+		for _, o := range owners {
+			names, _ := readDirNames(filepath.Join(d.dir, o))
+			for _, n := range names {
+				r := &CacheRepository{
+					Ident: d.ident,
+					Owner: o,
+					Name:  n,
+					Path:  filepath.Join(d.dir, o, n),
+				}
+				r.Size, _ = dirSize(r.Path)
+				keep, _ := filepath.Glob(filepath.Join(r.Path, "files", "*", ".keep"))
+				for _, k := range keep {
+					r.Keep = append(r.Keep, filepath.Base(filepath.Dir(k)))
+				}
+				res = append(res, r)
+			}
+		}
+
+		// metadata of repositories whose cache directory no longer exists
+		for _, metadir := range d.metadirs {
+			owners, _ := readDirNames(metadir)
+			for _, o := range owners {
+				names, _ := readDirNames(filepath.Join(metadir, o))
+				for _, n := range names {
+					if _, err := os.Stat(filepath.Join(d.dir, o, n)); nil == err {
+						continue
+					}
+					r := &CacheRepository{
+						Ident:  d.ident,
+						Owner:  o,
+						Name:   n,
+						Path:   filepath.Join(metadir, o, n),
+						Orphan: true,
+					}
+					r.Size, _ = dirSize(r.Path)
+					res = append(res, r)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// RemoveCache removes the cache directory and metadata of a repository (or its metadata
+// only if the cache directory no longer exists). The ident must be empty when root is an
+// explicitly configured cache directory.
+func RemoveCache(root string, idents bool, ident string, owner string, name string) (err error) {
+	dirs, err := cacheDirs(root, idents)
+	if nil != err {
+		return
+	}
+
+	for _, d := range dirs {
+		if ident != d.ident {
+			continue
+		}
+		found := false
+		for _, path := range append([]string{d.dir}, d.metadirs...) {
+			path = filepath.Join(path, owner, name)
+			if _, e := os.Stat(path); nil != e {
+				continue
+			}
+			found = true
+			err = removeDir(path)
+			if nil != err {
+				return
+			}
+		}
+		if found {
+			return nil
+		}
+		break
+	}
+
+	return ErrNotFound
+}
+
+// CollectCache removes the directories under root that were left behind by interrupted
+// removals and orphaned metadata (see ListCache). The function fn (if not nil) is called
+// for each directory removed.
+func CollectCache(root string, idents bool, fn func(path string)) (count int, err error) {
+	var walk func(dir string, depth int)
+	walk = func(dir string, depth int) {
+		infos, _ := ioutil.ReadDir(dir)
+		for _, info := range infos {
+			if !info.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, info.Name())
+			if staleRe.MatchString(info.Name()) {
+				if nil == os.RemoveAll(path) {
+					count++
+					if nil != fn {
+						fn(path)
+					}
+				}
+			} else if 0 < depth && "files" != info.Name() && "objects" != info.Name() {
+				walk(path, depth-1)
+			}
+		}
+	}
+
+	_, err = os.Stat(root)
+	if nil != err {
+		return
+	}
+
+	// stale directories are at most at root/.meta/ident/owner/repo
+	walk(root, 3)
+
+	lst, _ := ListCache(root, idents)
+	for _, r := range lst {
+		if r.Orphan && nil == removeDir(r.Path) {
+			count++
+			if nil != fn {
+				fn(r.Path)
+			}
+		}
+	}
+	return
+}
+
+// removeDir removes a directory by renaming it first, so that an interrupted removal
+// leaves behind a directory that is recognized as stale.
+func removeDir(path string) error {
+	tmpdir := path + time.Now().Format(".20060102T150405.000Z")
+	err := os.Rename(path, tmpdir)
+	if nil != err {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.RemoveAll(tmpdir)
+}
+
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if nil == err && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
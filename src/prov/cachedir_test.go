@@ -0,0 +1,130 @@
+/*
+ * cachedir_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "cachedir_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	write := func(rel string, size int) {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0700)
+		err := ioutil.WriteFile(p, make([]byte, size), 0600)
+		if nil != err {
+			t.Fatal(err)
+		}
+	}
+	exists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+		return nil == err
+	}
+
+	write("github.com/o1/r1/objects/00/0000", 100)
+	write("github.com/o1/r1/files/master/.keep", 0)
+	write("github.com/o1/r1/files/master/file", 10)
+	write("github.com/o1/r2/objects/11/1111", 200)
+	write("github.com/o1/r2.20220101T010203.456Z/objects/22/2222", 100)
+	write("gitlab.com/o2/r3/objects/33/3333", 300)
+	write("gitlab.com.20220101T010203.456Z/o2/r3/objects/33/3333", 300)
+	write(".meta/github.com/o1/r2/refs.json", 10)
+	write(".meta/github.com/o9/r9/refs.json", 10)
+	write("gitlab.com/.meta/o2/r3/refs.json", 10)
+	write("gitlab.com/.meta/o2/r4/refs.json", 10)
+	write(".meta/sourcehut.org/o3/r5/refs.json", 10)
+	write(".objects/44/4444", 100)
+
+	lst, err := ListCache(root, true)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 6 != len(lst) {
+		t.Fatal(len(lst))
+	}
+	if "github.com" != lst[0].Ident || "o1" != lst[0].Owner || "r1" != lst[0].Name ||
+		110 != lst[0].Size || 1 != len(lst[0].Keep) || "master" != lst[0].Keep[0] {
+		t.Error(lst[0])
+	}
+	if "r2" != lst[1].Name || 200 != lst[1].Size || 0 != len(lst[1].Keep) {
+		t.Error(lst[1])
+	}
+	if "o9" != lst[2].Owner || "r9" != lst[2].Name || !lst[2].Orphan ||
+		filepath.Join(root, ".meta", "github.com", "o9", "r9") != lst[2].Path {
+		t.Error(lst[2])
+	}
+	if "gitlab.com" != lst[3].Ident || "o2" != lst[3].Owner || "r3" != lst[3].Name || lst[3].Orphan {
+		t.Error(lst[3])
+	}
+	if "gitlab.com" != lst[4].Ident || "r4" != lst[4].Name || !lst[4].Orphan {
+		t.Error(lst[4])
+	}
+	if "sourcehut.org" != lst[5].Ident || "r5" != lst[5].Name || !lst[5].Orphan {
+		t.Error(lst[5])
+	}
+
+	lst, err = ListCache(filepath.Join(root, "github.com"), false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(lst) || "" != lst[0].Ident || "r1" != lst[0].Name || "r2" != lst[1].Name {
+		t.Error(lst)
+	}
+
+	err = RemoveCache(root, true, "github.com", "o1", "r2")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if exists("github.com/o1/r2") || exists(".meta/github.com/o1/r2") || !exists("github.com/o1/r1") {
+		t.Error()
+	}
+	err = RemoveCache(root, true, "github.com", "o1", "r2")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+	err = RemoveCache(root, true, "sourcehut.org", "o3", "r5")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if exists(".meta/sourcehut.org/o3/r5") {
+		t.Error()
+	}
+
+	removed := []string{}
+	count, err := CollectCache(root, true, func(path string) {
+		removed = append(removed, path)
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 4 != count || 4 != len(removed) {
+		t.Error(count, removed)
+	}
+	if exists("github.com/o1/r2.20220101T010203.456Z") || exists("gitlab.com.20220101T010203.456Z") ||
+		!exists("gitlab.com/o2/r3") || !exists(".objects/44/4444") {
+		t.Error()
+	}
+	if exists(".meta/github.com/o9/r9") || exists("gitlab.com/.meta/o2/r4") ||
+		!exists("gitlab.com/.meta/o2/r3") {
+		t.Error()
+	}
+}
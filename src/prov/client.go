@@ -14,6 +14,11 @@
 package prov
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,16 +29,24 @@ import (
 )
 
 type client struct {
-	api      clientApi
-	dir      string
-	keepdir  bool
-	caseins  bool
-	fullrefs bool
-	ttl      time.Duration
-	lock     sync.Mutex
-	cache    *cache
-	owners   *cacheImap
-	filter   *filterType
+	api       clientApi
+	dir       string
+	metadir   string
+	objdir    string
+	keepdir   bool
+	caseins   bool
+	fullrefs  bool
+	ssh       bool
+	sshkey    string
+	offline   bool
+	cachesize int64
+	ttl       time.Duration
+	refresh   time.Duration
+	lock      sync.Mutex
+	cache     *cache
+	owners    *cacheImap
+	filter    *filterType
+	objcache  *objectCache
 }
 
 type owner struct {
@@ -46,9 +59,10 @@ type owner struct {
 type repository struct {
 	cacheItem
 	Repository
-	keepdir bool
-	FName   string
-	FRemote string
+	keepdir    bool
+	FName      string
+	FRemote    string
+	FSshRemote string
 }
 
 type clientApi interface {
@@ -58,6 +72,47 @@ type clientApi interface {
 	getRepositories(owner string, kind string) (res []*repository, err error)
 }
 
+// treeSizer is implemented by clients whose provider API reports the sizes of the
+// blobs in a tree. It returns a map of blob hash to size.
+type treeSizer interface {
+	getTreeSizes(owner string, repo string, tree string) (res map[string]int64, err error)
+}
+
+// getTreeSizes gets the sizes of the blobs in a tree using a tree listing of the form
+// {"tree": [{"type": ..., "sha": ..., "size": ...}]} (GitHub and Gitea). The format is
+// the path of the listing with placeholders for owner, repo and tree. A truncated
+// listing is fine; the sizes of missing entries are determined otherwise.
+func getTreeSizes(sendrecv func(path string) (*http.Response, error),
+	format string, owner string, repo string, tree string) (res map[string]int64, err error) {
+	rsp, err := sendrecv(fmt.Sprintf(format,
+		url.PathEscape(owner), url.PathEscape(repo), url.PathEscape(tree)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Tree []struct {
+			Type string `json:"type"`
+			Hash string `json:"sha"`
+			Size int64  `json:"size"`
+		} `json:"tree"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make(map[string]int64, len(content.Tree))
+	for _, elm := range content.Tree {
+		if "blob" == elm.Type {
+			res[elm.Hash] = elm.Size
+		}
+	}
+
+	return res, nil
+}
+
 func (c *client) init(api clientApi) {
 	c.api = api
 	c.cache = newCache(&c.lock)
@@ -84,17 +139,36 @@ func (c *client) SetConfig(config []string) ([]string, error) {
 						n := strings.TrimSuffix(filepath.Base(p), ".exe")
 						v = filepath.Join(d, n, c.api.getIdent())
 						c.dir = v
+						c.metadir = filepath.Join(d, n, ".meta", c.api.getIdent())
 						c.keepdir = false
 					}
 				}
 			} else {
 				c.dir = v
+				c.metadir = filepath.Join(v, ".meta")
 				c.keepdir = true
 			}
+		case configValue(s, "config.objdir=", &v):
+			if ":" == v {
+				if d, e := appdata.CacheDir(); nil == e {
+					if p, e := os.Executable(); nil == e {
+						n := strings.TrimSuffix(filepath.Base(p), ".exe")
+						c.objdir = filepath.Join(d, n, ".objects")
+					}
+				}
+			} else if "" != v {
+				c.objdir = filepath.Clean(v)
+			} else {
+				c.objdir = ""
+			}
 		case configValue(s, "config.ttl=", &v):
 			if ttl, e := time.ParseDuration(v); nil == e && 0 < ttl {
 				c.ttl = ttl
 			}
+		case configValue(s, "config.refresh=", &v):
+			if refresh, e := time.ParseDuration(v); nil == e && 0 <= refresh {
+				c.refresh = refresh
+			}
 		case configValue(s, "config._caseins=", &v):
 			if "1" == v {
 				c.caseins = true
@@ -107,6 +181,25 @@ func (c *client) SetConfig(config []string) ([]string, error) {
 			} else {
 				c.fullrefs = false
 			}
+		case configValue(s, "config.ssh=", &v):
+			if "1" == v {
+				c.ssh = true
+			} else {
+				c.ssh = false
+			}
+		case configValue(s, "config.sshkey=", &v):
+			c.sshkey = v
+			c.ssh = "" != v
+		case configValue(s, "config.offline=", &v):
+			if "1" == v {
+				c.offline = true
+			} else {
+				c.offline = false
+			}
+		case configValue(s, "config.cachesize=", &v):
+			if n, ok := parseCacheSize(v); ok {
+				c.cachesize = n
+			}
 		case configValue(s, "config._filter=", &v):
 			if nil == c.filter {
 				c.filter = &filterType{}
@@ -117,6 +210,18 @@ func (c *client) SetConfig(config []string) ([]string, error) {
 		}
 	}
 
+	if !c.keepdir && "" == c.objdir && "" != c.dir {
+		// the metadata of the default cache directory is removed with it; it is kept
+		// across mounts only together with a persistent object store
+		c.metadir = filepath.Join(c.dir, ".meta")
+	}
+
+	if c.offline && !c.keepdir && "" == c.objdir {
+		// the default cache directory is removed when expiration stops; see StopExpiration
+		return nil, errors.New("offline mode requires a persistent cache " +
+			"(config.dir=DIR or config.objdir=DIR)")
+	}
+
 	return res, nil
 }
 
@@ -151,7 +256,7 @@ func (c *client) OpenOwner(name string) (Owner, error) {
 	}
 	c.lock.Unlock()
 
-	res, err = c.api.getOwner(name)
+	res, err = c.getOwner(name)
 	if nil != err {
 		return nil, err
 	}
@@ -171,6 +276,89 @@ func (c *client) OpenOwner(name string) (Owner, error) {
 	return res, nil
 }
 
+// ownerPath returns the path of a file in the metadata directory that stores
+// information about an owner. It is used for offline mode.
+func (c *client) ownerPath(name string, file string) string {
+	if "" == c.metadir {
+		return ""
+	}
+	if c.caseins {
+		name = strings.ToUpper(name)
+	}
+	return filepath.Join(c.metadir, ".owners", name, file)
+}
+
+type metaOwner struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type metaRepository struct {
+	Name      string `json:"name"`
+	Remote    string `json:"remote"`
+	SshRemote string `json:"sshremote,omitempty"`
+}
+
+// getOwner gets an owner from the provider and records it in the metadata directory.
+// In offline mode the recorded owner is used instead.
+func (c *client) getOwner(name string) (*owner, error) {
+	if c.offline {
+		var m metaOwner
+		if !readMetaFile(c.ownerPath(name, "owner.json"), &m) {
+			return nil, ErrNotFound
+		}
+		res := &owner{
+			FName: m.Name,
+			FKind: m.Kind,
+		}
+		res.Value = res
+		return res, nil
+	}
+
+	res, err := c.api.getOwner(name)
+	if nil != err {
+		return nil, err
+	}
+	writeMetaFile(c.ownerPath(res.FName, "owner.json"), &metaOwner{res.FName, res.FKind})
+	return res, nil
+}
+
+// getRepositories gets the repositories of an owner from the provider and records
+// them in the metadata directory. In offline mode the recorded repositories are used
+// instead.
+func (c *client) getRepositories(owner string, kind string) ([]*repository, error) {
+	if c.offline {
+		var lst []metaRepository
+		if !readMetaFile(c.ownerPath(owner, "repositories.json"), &lst) {
+			return nil, ErrNotFound
+		}
+		res := make([]*repository, len(lst))
+		for i, m := range lst {
+			r := &repository{
+				FName:      m.Name,
+				FRemote:    m.Remote,
+				FSshRemote: m.SshRemote,
+			}
+			r.Value = r
+			r.Repository = emptyRepository
+			r.keepdir = c.keepdir
+			res[i] = r
+		}
+		return res, nil
+	}
+
+	res, err := c.api.getRepositories(owner, kind)
+	if nil != err {
+		return nil, err
+	}
+	lst := make([]metaRepository, len(res))
+	for i, r := range res {
+		lst[i] = metaRepository{r.FName, r.FRemote, r.FSshRemote}
+	}
+	writeMetaFile(c.ownerPath(owner, "repositories.json"), lst)
+	return res, nil
+}
+
 func (c *client) CloseOwner(O Owner) {
 	c.lock.Lock()
 	c.cache.touchCacheItem(&O.(*owner).cacheItem, -1)
@@ -186,7 +374,7 @@ func (c *client) ensureRepositories(o *owner, fn func() error) error {
 	}
 	c.lock.Unlock()
 
-	repositories, err := c.api.getRepositories(o.FName, o.FKind)
+	repositories, err := c.getRepositories(o.FName, o.FKind)
 	if nil != err {
 		return err
 	}
@@ -225,8 +413,44 @@ func (c *client) GetRepositories(O Owner) ([]Repository, error) {
 	return res, err
 }
 
+// ensureObjectCache returns the object cache that limits the size of the cache
+// directory (or the shared object store). It must be called with the client lock held;
+// the object cache must be indexed after the lock is released.
+func (c *client) ensureObjectCache() *objectCache {
+	root := c.dir
+	if "" != c.objdir {
+		root = c.objdir
+	}
+	if nil == c.objcache && 0 < c.cachesize && "" != root {
+		c.objcache = newObjectCache(root, c.cachesize)
+	}
+	return c.objcache
+}
+
+// objectStore returns the shared object store directory and the group and other
+// permissions that are granted on objects stored in it.
+func (c *client) objectStore() (string, os.FileMode) {
+	if "" == c.objdir {
+		return "", 0
+	}
+	err := os.MkdirAll(c.objdir, 0700)
+	if nil != err {
+		return "", 0
+	}
+	info, err := os.Stat(c.objdir)
+	if nil != err {
+		return "", 0
+	}
+	if 0 != info.Mode().Perm()&0022 && 0 == info.Mode()&os.ModeSticky {
+		// only the owner of an object may replace it; see storeObject
+		os.Chmod(c.objdir, info.Mode().Perm()|os.ModeSticky)
+	}
+	return c.objdir, info.Mode().Perm() & 0077
+}
+
 func (c *client) OpenRepository(O Owner, name string) (Repository, error) {
 	var res *repository
+	var objcache *objectCache
 	var err error
 
 	o := O.(*owner)
@@ -237,14 +461,35 @@ func (c *client) OpenRepository(O Owner, name string) (Repository, error) {
 		}
 		res = item.Value.(*repository)
 		if emptyRepository == res.Repository {
+			remote := res.FRemote
+			if c.ssh && "" != res.FSshRemote {
+				remote = res.FSshRemote
+			}
 			u, p := c.api.getGitCredentials()
-			r := newGitRepository(res.FRemote, u, p, c.caseins, c.fullrefs)
+			r := newGitRepository(remote, u, p, c.caseins, c.fullrefs)
+			r.sshkey = c.sshkey
+			r.offline = c.offline
+			r.refresh = c.refresh
+			r.store, r.storeperm = c.objectStore()
+			r.objcache = c.ensureObjectCache()
+			objcache = r.objcache
+			if s, ok := c.api.(treeSizer); ok && !c.offline {
+				oname, rname := o.FName, res.FName
+				r.sizer = func(tree string) (map[string]int64, error) {
+					return s.getTreeSizes(oname, rname, tree)
+				}
+			}
 			if "" != c.dir {
 				err = r.SetDirectory(filepath.Join(c.dir, o.FName, res.FName))
 				if nil != err {
 					return err
 				}
 			}
+			if "" != c.metadir {
+				// metadata is kept across mounts with a persistent object store even
+				// when the cache directory is not
+				r.meta = filepath.Join(c.metadir, o.FName, res.FName)
+			}
 			res.Repository = r
 		}
 		c.cache.touchCacheItem(&res.cacheItem, +1)
@@ -254,6 +499,9 @@ func (c *client) OpenRepository(O Owner, name string) (Repository, error) {
 		return nil, err
 	}
 
+	// index existing objects without holding the client lock
+	objcache.index()
+
 	return res, nil
 }
 
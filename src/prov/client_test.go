@@ -0,0 +1,53 @@
+/*
+ * client_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+)
+
+// newTestApiServer starts a local API server for client tests. The auth function (if
+// not nil) checks the credentials of a request and responds to the requests that it
+// rejects. Requests are dispatched by path; unknown paths are answered with 404.
+func newTestApiServer(
+	auth func(w http.ResponseWriter, req *http.Request) bool,
+	routes map[string]http.HandlerFunc) *httptest.Server {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if nil != auth && !auth(w, req) {
+			return
+		}
+		if route, ok := routes[req.URL.Path]; ok {
+			route(w, req)
+		} else {
+			w.WriteHeader(404)
+		}
+	}
+	return httptest.NewServer(http.HandlerFunc(handler))
+}
+
+// testReply returns a route that responds with the specified body.
+func testReply(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, body)
+	}
+}
+
+// testStatus returns a route that responds with the specified status code.
+func testStatus(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(code)
+	}
+}
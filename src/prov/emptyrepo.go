@@ -52,6 +52,10 @@ func (*emptyRepositoryT) GetRefs() ([]Ref, error) {
 	return []Ref{}, nil
 }
 
+func (*emptyRepositoryT) RefreshRefs() error {
+	return nil
+}
+
 func (*emptyRepositoryT) GetRef(name string) (Ref, error) {
 	return nil, ErrNotFound
 }
@@ -76,6 +80,18 @@ func (*emptyRepositoryT) GetModule(ref Ref, path string, rootrel bool) (string,
 	return "", ErrNotFound
 }
 
+func (*emptyRepositoryT) GetGitDir(ref Ref) (*GitDir, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetObject(hash string) (string, []byte, error) {
+	return "", nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) PushChanges(ref Ref, changes []*Change, info *PushInfo) (string, error) {
+	return "", ErrNotFound
+}
+
 func init() {
 	emptyRepository = &emptyRepositoryT{}
 }
@@ -0,0 +1,293 @@
+/*
+ * generic.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+type GenericProvider struct {
+	Remote   string
+	Username string
+}
+
+// NewGenericProvider creates a provider for an arbitrary git remote. The remote must have
+// owner and repo path components; otherwise the provider is not created, so that an
+// unknown host is not mistaken for a generic remote.
+func NewGenericProvider(uri *url.URL) Provider {
+	u := *uri
+	if "ssh" != u.Scheme {
+		u.User = nil
+	}
+	if _, _, err := genericRemoteName(RemoteString(&u)); nil != err {
+		return nil
+	}
+	p := &GenericProvider{Remote: RemoteString(&u)}
+	if nil != uri.User {
+		p.Username = uri.User.Username()
+	}
+	return p
+}
+
+func init() {
+	help := "" +
+		"%s://host/owner/repo[.git]\n" +
+		"    \taccess arbitrary git remote using the %s protocol\n" +
+		"    \t- owner     first path component of remote\n" +
+		"    \t- repo      remaining path components of remote\n" +
+		"    \t- additional remotes: -o config.remote=URL or -o config.remotes=FILE"
+	RegisterProviderClass("https:", NewGenericProvider, fmt.Sprintf(help, "https", "smart HTTP"))
+	RegisterProviderClass("http:", NewGenericProvider, fmt.Sprintf(help, "http", "smart HTTP"))
+	RegisterProviderClass("ssh:", NewGenericProvider, fmt.Sprintf(help, "ssh", "SSH")+"\n"+
+		"    \t- scp-like syntax: [user@]host:owner/repo[.git]\n"+
+		"    \t- ssh auth: ssh-agent or -o config.sshkey=FILE")
+}
+
+func (p *GenericProvider) Auth() (token string, err error) {
+	// arbitrary git remotes have no OAuth flow; use anonymous access (token is not stored)
+	return "", nil
+}
+
+func (p *GenericProvider) NewClient(token string) (Client, error) {
+	return NewGenericClient(p.Remote, p.Username, token)
+}
+
+// Prefix returns the file system prefix that corresponds to the provider remote.
+func (p *GenericProvider) Prefix() string {
+	o, r, err := genericRemoteName(p.Remote)
+	if nil != err {
+		return ""
+	}
+	return "/" + o + "/" + r
+}
+
+type genericClient struct {
+	client
+	hosts    []string
+	username string
+	token    string
+	remotes  map[string]map[string]string
+}
+
+func NewGenericClient(remote string, username string, token string) (Client, error) {
+	c := &genericClient{
+		username: username,
+		token:    token,
+		remotes:  make(map[string]map[string]string),
+	}
+	c.client.init(c)
+
+	if "" != remote {
+		err := c.addRemote(remote)
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func genericRemoteName(remote string) (owner string, repo string, err error) {
+	uri, err := ParseRemote(remote)
+	if nil != err {
+		return "", "", err
+	}
+	// a home relative path (e.g. git@host:~/owner/repo) is named after the rest of the path
+	comp := strings.Split(strings.TrimPrefix(strings.Trim(uri.Path, "/"), "~/"), "/")
+	if 2 > len(comp) || "" == comp[0] {
+		return "", "", errors.New("remote must have owner and repo path components: " + remote)
+	}
+	owner = comp[0]
+	repo = strings.TrimSuffix(strings.Join(comp[1:], string(AltPathSeparator)), ".git")
+	return
+}
+
+func (c *genericClient) addRemote(remote string) error {
+	uri, err := ParseRemote(remote)
+	if nil != err {
+		return err
+	}
+	switch uri.Scheme {
+	case "http", "https":
+		uri.User = nil
+	case "ssh":
+		// ssh user is part of the remote; keys are supplied by ssh-agent or config.sshkey
+	default:
+		return errors.New("remote must use http, https or ssh scheme: " + remote)
+	}
+	remote = RemoteString(uri)
+
+	o, r, err := genericRemoteName(remote)
+	if nil != err {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	m := c.remotes[o]
+	if nil == m {
+		m = make(map[string]string)
+		c.remotes[o] = m
+	}
+	if other, ok := m[r]; ok && other != remote {
+		return errors.New("remote conflicts with " + other + ": " + remote)
+	}
+	m[r] = remote
+	host := uri.Hostname()
+	found := false
+	for _, h := range c.hosts {
+		if h == host {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.hosts = append(c.hosts, host)
+	}
+
+	return nil
+}
+
+func (c *genericClient) addRemoteList(path string) error {
+	file, err := os.Open(path)
+	if nil != err {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		err = c.addRemote(line)
+		if nil != err {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (c *genericClient) SetConfig(config []string) ([]string, error) {
+	res := []string{}
+	for _, s := range config {
+		v := ""
+		switch {
+		case configValue(s, "config.remote=", &v):
+			err := c.addRemote(v)
+			if nil != err {
+				return nil, err
+			}
+		case configValue(s, "config.remotes=", &v):
+			err := c.addRemoteList(v)
+			if nil != err {
+				return nil, err
+			}
+		default:
+			res = append(res, s)
+		}
+	}
+
+	return c.client.SetConfig(res)
+}
+
+func (c *genericClient) GetOwners() ([]Owner, error) {
+	c.lock.Lock()
+	names := make([]string, 0, len(c.remotes))
+	for n := range c.remotes {
+		if nil != c.filter && !c.filter.match(n) {
+			continue
+		}
+		names = append(names, n)
+	}
+	c.lock.Unlock()
+
+	sort.Strings(names)
+	res := make([]Owner, len(names))
+	for i, n := range names {
+		res[i] = &owner{FName: n}
+	}
+
+	return res, nil
+}
+
+// getIdent returns the hosts of all remotes, so that repositories of different hosts that
+// share an owner/repo name do not share a cache directory.
+func (c *genericClient) getIdent() string {
+	c.lock.Lock()
+	ident := strings.Join(c.hosts, string(AltPathSeparator))
+	c.lock.Unlock()
+	if "" == ident {
+		ident = "git"
+	}
+	return ident
+}
+
+func (c *genericClient) getGitCredentials() (string, string) {
+	if "" == c.token {
+		return "", ""
+	}
+	username := c.username
+	if "" == username {
+		username = "git"
+	}
+	return username, c.token
+}
+
+func (c *genericClient) getOwner(o string) (res *owner, err error) {
+	defer trace(o)(&err)
+
+	c.lock.Lock()
+	_, ok := c.remotes[o]
+	c.lock.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	res = &owner{
+		FName: o,
+		FKind: "remote",
+	}
+	res.Value = res
+	return
+}
+
+func (c *genericClient) getRepositories(owner string, kind string) (res []*repository, err error) {
+	defer trace(owner)(&err)
+
+	c.lock.Lock()
+	m := c.remotes[owner]
+	res = make([]*repository, 0, len(m))
+	for n, remote := range m {
+		r := &repository{
+			FName:   n,
+			FRemote: remote,
+		}
+		r.Value = r
+		r.Repository = emptyRepository
+		r.keepdir = c.keepdir
+		res = append(res, r)
+	}
+	c.lock.Unlock()
+
+	return res, nil
+}
@@ -0,0 +1,120 @@
+/*
+ * generic_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"testing"
+)
+
+func TestGenericRemoteName(t *testing.T) {
+	expect := func(remote string, eowner string, erepo string, eok bool) {
+		owner, repo, err := genericRemoteName(remote)
+		if eok != (nil == err) || eowner != owner || erepo != repo {
+			t.Errorf("remote %q expect (%q, %q, %v) got (%q, %q, %v)",
+				remote, eowner, erepo, eok, owner, repo, err)
+		}
+	}
+
+	expect("https://git.example.internal/team/repo.git", "team", "repo", true)
+	expect("https://git.example.internal/team/repo", "team", "repo", true)
+	expect("https://git.example.internal/team/repo/", "team", "repo", true)
+	expect("https://git.example.internal/team/sub/repo.git", "team", "sub+repo", true)
+	expect("https://git.example.internal/repo.git", "", "", false)
+	expect("https://git.example.internal", "", "", false)
+	expect("ssh://git@git.example.internal/team/repo.git", "team", "repo", true)
+	expect("git@git.example.internal:team/sub/repo.git", "team", "sub+repo", true)
+	expect("git@git.example.internal:repo.git", "", "", false)
+	expect("git@git.example.internal:~/team/repo.git", "team", "repo", true)
+	expect("git@git.example.internal:~/repo.git", "", "", false)
+}
+
+func TestGenericClient(t *testing.T) {
+	c, err := NewGenericClient("https://user@git.example.internal/team/repo.git", "user", "")
+	if nil != err {
+		t.Error(err)
+	}
+
+	_, err = c.SetConfig([]string{"config.remote=https://git.example.internal/team/other.git"})
+	if nil != err {
+		t.Error(err)
+	}
+
+	owners, err := c.GetOwners()
+	if nil != err {
+		t.Error(err)
+	}
+	if 1 != len(owners) || "team" != owners[0].Name() {
+		t.Error()
+	}
+
+	owner, err := c.OpenOwner("team")
+	if nil != err {
+		t.Error(err)
+	}
+	defer c.CloseOwner(owner)
+
+	repositories, err := c.GetRepositories(owner)
+	if nil != err {
+		t.Error(err)
+	}
+	if 2 != len(repositories) {
+		t.Error()
+	}
+
+	_, err = c.OpenOwner("nonexistent")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+
+	if "git.example.internal" != c.(*genericClient).getIdent() {
+		t.Error(c.(*genericClient).getIdent())
+	}
+
+	_, err = c.SetConfig([]string{"config.remote=https://git.example.com/team/repo.git"})
+	if nil == err {
+		t.Error()
+	}
+	_, err = c.SetConfig([]string{"config.remote=ssh://git@git.example.com/team/third.git"})
+	if nil != err {
+		t.Error(err)
+	}
+	if "git.example.internal+git.example.com" != c.(*genericClient).getIdent() {
+		t.Error(c.(*genericClient).getIdent())
+	}
+}
+
+func TestGenericProvider(t *testing.T) {
+	expect := func(remote string, eremote string) {
+		uri, err := ParseRemote(remote)
+		if nil != err {
+			t.Fatal(err)
+		}
+		p := NewProviderInstance(uri)
+		if "" == eremote {
+			if nil != p {
+				t.Errorf("remote %q expect no provider", remote)
+			}
+			return
+		}
+		if g, ok := p.(*GenericProvider); !ok || eremote != g.Remote {
+			t.Errorf("remote %q expect %q got %v", remote, eremote, p)
+		}
+	}
+
+	expect("https://user@git.example.internal/team/repo.git", "https://git.example.internal/team/repo.git")
+	expect("ssh://git@git.example.internal/team/repo", "ssh://git@git.example.internal/team/repo")
+	expect("git@git.example.internal:~/team/repo", "git@git.example.internal:~/team/repo")
+	expect("https://git.example.internal", "")
+	expect("https://git.example.internal/team", "")
+}
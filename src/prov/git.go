@@ -16,6 +16,7 @@ package prov
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/url"
@@ -30,17 +31,43 @@ import (
 	"github.com/winfsp/hubfs/git"
 )
 
+type gitRemote interface {
+	io.Closer
+	ListRefs(prefixes []string) (map[string]string, error)
+	GetObjectSizes(wants []string) (map[string]int64, error)
+	FetchObjects(wants []string, fn func(hash string, ot git.ObjectType, content []byte) error) error
+	FetchObjectFiles(wants []string, dir string, fn func(hash string, ot git.ObjectType) error) error
+	Push(refname string, oldhash string, newhash string, objects map[string]*git.Object) error
+}
+
 type gitRepository struct {
-	remote   string
-	username string
-	password string
-	caseins  bool
-	fullrefs bool
-	once     sync.Once
-	repo     *git.Repository
-	lock     sync.RWMutex
-	refs     map[string]*gitRef
-	dir      string
+	remote    string
+	username  string
+	password  string
+	sshkey    string
+	caseins   bool
+	fullrefs  bool
+	local     bool
+	once      sync.Once
+	repo      gitRemote
+	lock      sync.RWMutex
+	refs      map[string]*gitRef
+	norefs    map[string]bool
+	dir       string
+	spool     string
+	sizer     func(tree string) (map[string]int64, error)
+	objcache  *objectCache
+	meta      string
+	offline   bool
+	refresh   time.Duration
+	refsTime  time.Time
+	stale     bool
+	store     string
+	storeperm os.FileMode
+	vlock     sync.Mutex
+	verified  map[string]os.FileInfo
+	gitrefs   map[string]string
+	gitTime   time.Time
 }
 
 type gitRef struct {
@@ -53,10 +80,13 @@ type gitRef struct {
 }
 
 type gitTreeEntry struct {
-	entry  git.TreeEntry
-	size   int64
-	target string
-	tree   map[string]*gitTreeEntry
+	entry   git.TreeEntry
+	size    int64
+	target  string
+	tree    map[string]*gitTreeEntry
+	path    string
+	rules   []git.LfsRule
+	pointer *git.LfsPointer
 }
 
 func NewGitRepository(
@@ -66,6 +96,7 @@ func NewGitRepository(
 		username: username,
 		password: password,
 		caseins:  caseins,
+		local:    strings.HasPrefix(remote, "file:"),
 	}
 
 	var err error
@@ -78,25 +109,71 @@ func NewGitRepository(
 }
 
 func newGitRepository(
-	remote string, username string, password string, caseins bool, fullrefs bool) Repository {
+	remote string, username string, password string, caseins bool, fullrefs bool) *gitRepository {
 	return &gitRepository{
 		remote:   remote,
 		username: username,
 		password: password,
 		caseins:  caseins,
 		fullrefs: fullrefs,
+		local:    strings.HasPrefix(remote, "file:"),
 	}
 }
 
 func (r *gitRepository) open() (err error) {
-	r.repo, err = git.OpenRepository(r.remote, r.username, r.password)
+	if r.local {
+		var uri *url.URL
+		uri, err = url.Parse(r.remote)
+		if nil != err {
+			return
+		}
+		var repo *git.LocalRepository
+		repo, err = git.OpenLocalRepository(localPath(uri))
+		if nil == err {
+			r.repo = repo
+		}
+	} else if git.IsSshRemote(r.remote) {
+		// SSH authenticates using keys; the HTTP credentials do not apply
+		var repo *git.Repository
+		repo, err = git.OpenSshRepository(r.remote, r.sshkey)
+		if nil == err {
+			r.repo = repo
+		}
+	} else {
+		var repo *git.Repository
+		repo, err = git.OpenRepository(r.remote, r.username, r.password)
+		if nil == err {
+			r.repo = repo
+		}
+	}
 	return
 }
 
+var errOffline = errors.New("content not available offline")
+
+// ensureOpen opens the git remote on first use. Content that is available from the
+// cache or metadata directories does not require the remote to be opened.
+func (r *gitRepository) ensureOpen() error {
+	if r.offline {
+		return errOffline
+	}
+	r.once.Do(func() { r.open() })
+	if nil == r.repo {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (r *gitRepository) Close() (err error) {
 	if nil != r.repo {
 		err = r.repo.Close()
 	}
+	r.lock.Lock()
+	if "" != r.spool {
+		os.RemoveAll(r.spool)
+		r.spool = ""
+	}
+	r.lock.Unlock()
 	return
 }
 
@@ -127,6 +204,7 @@ func (r *gitRepository) RemoveDirectory() (err error) {
 		r.lock.Unlock()
 		return
 	}
+	r.objcache.removeDir(r.dir)
 	tmpdir := r.dir + time.Now().Format(".20060102T150405.000Z")
 	err = os.Rename(r.dir, tmpdir)
 	if nil == err {
@@ -139,6 +217,47 @@ func (r *gitRepository) RemoveDirectory() (err error) {
 	return
 }
 
+// objdir returns the object cache directory. Local repositories are not cached,
+// because their objects are already on local disk. When a shared object store is
+// used, objects are kept there rather than in the repository cache directory.
+func (r *gitRepository) objdir() string {
+	if r.local {
+		return ""
+	}
+	if "" != r.store {
+		return r.store
+	}
+	return r.dir
+}
+
+// metadir returns the metadata directory. Local repositories are not cached.
+func (r *gitRepository) metadir() string {
+	if r.local {
+		return ""
+	}
+	return r.meta
+}
+
+// blobdir returns the directory where blob content is stored. Blobs are streamed to
+// disk rather than held in memory; without an object cache they are spooled to a
+// temporary directory that is removed when the repository is closed.
+func (r *gitRepository) blobdir(dir string) (string, error) {
+	if "" != dir {
+		return dir, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if "" == r.spool {
+		spool, err := ioutil.TempDir("", "hubfs-spool-")
+		if nil != err {
+			return "", err
+		}
+		r.spool = spool
+	}
+	return r.spool, nil
+}
+
 func objectPath(dir string, hash string) string {
 	if 2 < len(hash) {
 		return filepath.Join(dir, "objects", hash[:2], hash[2:])
@@ -146,19 +265,93 @@ func objectPath(dir string, hash string) string {
 	return ""
 }
 
-func writeObject(dir string, hash string, content []byte) {
+// statObject returns the size of an object and the size of its file on disk.
+func statObject(p string) (size int64, disksize int64, err error) {
+	file, err := git.OpenObjectFile(p)
+	if nil != err {
+		return
+	}
+	size, disksize = file.Size(), file.DiskSize()
+	file.Close()
+	return
+}
+
+// readObject reads the content of an object and returns it together with its type (0 if
+// unknown) and the size of its file on disk.
+func readObject(p string) (content []byte, ot git.ObjectType, disksize int64, err error) {
+	file, err := git.OpenObjectFile(p)
+	if nil != err {
+		return
+	}
+	defer file.Close()
+	content = make([]byte, file.Size())
+	_, err = file.ReadAt(content, 0)
+	if io.EOF == err {
+		err = nil
+	}
+	return content, file.Type(), file.DiskSize(), err
+}
+
+func (r *gitRepository) writeObject(dir string, hash string, ot git.ObjectType, content []byte) {
 	p := objectPath(dir, hash)
 	if nil == os.MkdirAll(filepath.Dir(p), 0700) {
-		err := ioutil.WriteFile(p+".tmp", content, 0700)
+		// the object directory may be shared with other processes; use a unique
+		// temporary file and rename it into place
+		file, err := ioutil.TempFile(filepath.Dir(p), ".tmp")
+		if nil != err {
+			return
+		}
+		writer := git.NewObjectFileWriter(file, ot)
+		_, err = writer.Write(content)
+		if nil == err {
+			err = writer.Close()
+		}
+		var info os.FileInfo
 		if nil == err {
-			err = os.Rename(p+".tmp", p)
+			info, err = file.Stat()
+		}
+		if e := file.Close(); nil == err {
+			err = e
+		}
+		if nil == err {
+			err = os.Rename(file.Name(), p)
 		}
 		if nil != err {
-			os.Remove(p + ".tmp")
+			os.Remove(file.Name())
+			if _, e := os.Stat(p); nil == e {
+				// object already present (possibly open and unable to be replaced)
+				err = nil
+				info = nil
+			}
+		}
+		if nil != info {
+			r.storeObject(dir, p, info.Size())
 		}
 	}
 }
 
+// storeObject records that an object was stored. In a shared object store the object
+// is made accessible with the group and other permissions of the store directory. The
+// object itself is never writable by group or other; directories that are writable by
+// group or other are made sticky, so that only the owner of an object can replace it.
+func (r *gitRepository) storeObject(dir string, p string, size int64) {
+	if 0 != r.storeperm && dir == r.store && strings.HasPrefix(p, dir+string(filepath.Separator)) {
+		dirmode := 0700 | r.storeperm
+		if 0 != r.storeperm&0022 {
+			dirmode |= os.ModeSticky
+		}
+		for q := p; dir != q; q = filepath.Dir(q) {
+			if q == p {
+				os.Chmod(q, 0600|r.storeperm&0044)
+			} else {
+				os.Chmod(q, dirmode)
+			}
+		}
+	}
+	r.setVerified(p)
+	r.objcache.use(p, size)
+}
+
 func containsString(l []string, s string) bool {
 	for _, i := range l {
 		if i == s {
@@ -175,44 +368,113 @@ func (r *gitRepository) prefetchObjects(dir string, want []string,
 		return nil
 	}
 
-	if "" != dir {
-		w := make([]string, 0, len(want))
-		for _, hash := range want {
-			info, err := os.Stat(objectPath(dir, hash))
+	dir, err := r.blobdir(dir)
+	if nil != err {
+		return err
+	}
+
+	w := make([]string, 0, len(want))
+	for _, hash := range want {
+		p := objectPath(dir, hash)
+		size, disksize, err := statObject(p)
+		if nil != err {
+			w = append(w, hash)
+		} else {
+			r.objcache.use(p, disksize)
+			err = fn(hash, size)
 			if nil != err {
-				w = append(w, hash)
-			} else {
-				err = fn(hash, info.Size())
-				if nil != err {
-					return err
-				}
+				return err
 			}
 		}
+	}
 
-		want = w
-		if 0 == len(want) {
-			return nil
-		}
+	want = w
+	if 0 == len(want) {
+		return nil
+	}
 
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
-			writeObject(dir, hash, content)
-			if !containsString(want, hash) {
-				return nil
-			}
-			info, err := os.Stat(objectPath(dir, hash))
+	if err := r.ensureOpen(); nil != err {
+		return err
+	}
+
+	return r.repo.FetchObjectFiles(want, filepath.Join(dir, "objects"),
+		func(hash string, ot git.ObjectType) error {
+			p := objectPath(dir, hash)
+			size, disksize, err := statObject(p)
 			if nil != err {
 				return err
 			}
-			return fn(hash, info.Size())
-		})
-	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+			r.storeObject(dir, p, disksize)
 			if !containsString(want, hash) {
 				return nil
 			}
-			return fn(hash, int64(len(content)))
+			return fn(hash, size)
 		})
+}
+
+// sizeObjects determines object sizes without fetching object content when possible.
+// Sizes are obtained from the object cache, the git server (if it supports the
+// object-info command) or the provider API (if it reports sizes for tree entries).
+// Objects whose size cannot be otherwise determined are fetched.
+func (r *gitRepository) sizeObjects(dir string, tree string, want []string,
+	fn func(hash string, size int64) error) error {
+
+	if 0 == len(want) {
+		return nil
 	}
+
+	apply := func(m map[string]int64) error {
+		w := make([]string, 0, len(want))
+		for _, hash := range want {
+			size, ok := m[hash]
+			if !ok {
+				w = append(w, hash)
+				continue
+			}
+			err := fn(hash, size)
+			if nil != err {
+				return err
+			}
+		}
+		want = w
+		return nil
+	}
+
+	if "" != dir {
+		m := make(map[string]int64, len(want))
+		for _, hash := range want {
+			p := objectPath(dir, hash)
+			if size, disksize, err := statObject(p); nil == err {
+				r.objcache.use(p, disksize)
+				m[hash] = size
+			}
+		}
+		err := apply(m)
+		if nil != err {
+			return err
+		}
+	}
+
+	if 0 < len(want) && nil == r.ensureOpen() {
+		if m, err := r.repo.GetObjectSizes(want); nil == err {
+			err = apply(m)
+			if nil != err {
+				return err
+			}
+		}
+	}
+
+	// the provider API is rate limited; use it only if the git server cannot help
+	if 0 < len(want) && nil != r.sizer {
+		if m, err := r.sizer(tree); nil == err {
+			err = apply(m)
+			if nil != err {
+				return err
+			}
+		}
+	}
+
+	return r.prefetchObjects(dir, want, fn)
 }
 
 func (r *gitRepository) fetchObjects(dir string, want []string,
@@ -225,10 +487,15 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 	if "" != dir {
 		w := make([]string, 0, len(want))
 		for _, hash := range want {
-			content, err := ioutil.ReadFile(objectPath(dir, hash))
+			p := objectPath(dir, hash)
+			content, ot, disksize, err := readObject(p)
+			if nil == err && !r.checkObject(p, hash, ot, bytes.NewReader(content), int64(len(content))) {
+				err = errCorrupted
+			}
 			if nil != err {
 				w = append(w, hash)
 			} else {
+				r.objcache.use(p, disksize)
 				err = fn(hash, content)
 				if nil != err {
 					return err
@@ -241,14 +508,22 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 			return nil
 		}
 
+		if err := r.ensureOpen(); nil != err {
+			return err
+		}
+
 		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
-			writeObject(dir, hash, content)
+			r.writeObject(dir, hash, ot, content)
 			if !containsString(want, hash) {
 				return nil
 			}
 			return fn(hash, content)
 		})
 	} else {
+		if err := r.ensureOpen(); nil != err {
+			return err
+		}
+
 		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
@@ -265,9 +540,13 @@ func (r *gitRepository) refetchObjects(dir string, want []string,
 		return nil
 	}
 
+	if err := r.ensureOpen(); nil != err {
+		return err
+	}
+
 	if "" != dir {
 		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
-			writeObject(dir, hash, content)
+			r.writeObject(dir, hash, ot, content)
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -283,19 +562,6 @@ func (r *gitRepository) refetchObjects(dir string, want []string,
 	}
 }
 
-type readerAt interface {
-	io.Reader
-	io.ReaderAt
-}
-
-type readerAtNopCloser struct {
-	readerAt
-}
-
-func (readerAtNopCloser) Close() error {
-	return nil
-}
-
 func (r *gitRepository) fetchReaders(dir string, want []string,
 	fn func(hash string, reader io.ReaderAt) error) error {
 
@@ -303,45 +569,57 @@ func (r *gitRepository) fetchReaders(dir string, want []string,
 		return nil
 	}
 
-	if "" != dir {
-		w := make([]string, 0, len(want))
-		for _, hash := range want {
-			reader, err := os.Open(objectPath(dir, hash))
+	dir, err := r.blobdir(dir)
+	if nil != err {
+		return err
+	}
+
+	w := make([]string, 0, len(want))
+	for _, hash := range want {
+		p := objectPath(dir, hash)
+		reader, err := r.objcache.openObject(p)
+		if nil == err && !r.checkObject(p, hash, reader.Type(), reader, reader.Size()) {
+			reader.Close()
+			err = errCorrupted
+		}
+		if nil != err {
+			w = append(w, hash)
+		} else {
+			err = fn(hash, reader)
 			if nil != err {
-				w = append(w, hash)
-			} else {
-				err = fn(hash, reader)
-				if nil != err {
-					return err
-				}
+				return err
 			}
 		}
+	}
 
-		want = w
-		if 0 == len(want) {
-			return nil
-		}
+	want = w
+	if 0 == len(want) {
+		return nil
+	}
 
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
-			writeObject(dir, hash, content)
-			if !containsString(want, hash) {
-				return nil
-			}
-			reader, err := os.Open(objectPath(dir, hash))
+	if err := r.ensureOpen(); nil != err {
+		return err
+	}
+
+	return r.repo.FetchObjectFiles(want, filepath.Join(dir, "objects"),
+		func(hash string, ot git.ObjectType) error {
+			p := objectPath(dir, hash)
+			info, err := os.Stat(p)
 			if nil != err {
 				return err
 			}
-			return fn(hash, reader)
-		})
-	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
+				r.storeObject(dir, p, info.Size())
 				return nil
 			}
-			reader := readerAtNopCloser{bytes.NewReader(content)}
+			// open (and pin) before storing, so that the object cannot be evicted
+			reader, err := r.objcache.openObject(p)
+			if nil != err {
+				return err
+			}
+			r.storeObject(dir, p, info.Size())
 			return fn(hash, reader)
 		})
-	}
 }
 
 func (r *gitRepository) Name() string {
@@ -349,25 +627,100 @@ func (r *gitRepository) Name() string {
 }
 
 func (r *gitRepository) ensureRefs(fn func(refs map[string]*gitRef) error) error {
-	r.once.Do(func() { r.open() })
-	if nil == r.repo {
-		return ErrNotFound
+	if !r.offline {
+		if err := r.ensureOpen(); nil != err {
+			return err
+		}
 	}
 
 	r.lock.RLock()
-	if nil != r.refs {
+	if nil != r.refs && !r.refsExpired() {
 		err := fn(r.refs)
 		r.lock.RUnlock()
 		return err
 	}
+	meta := r.metadir()
 	r.lock.RUnlock()
 
-	m, err := r.repo.GetRefs()
+	var m map[string]string
+	var err error
+	if r.offline {
+		// use the refs that were last known when online
+		var ok bool
+		m, ok = r.loadRefs(meta)
+		if !ok {
+			err = ErrNotFound
+		}
+	} else {
+		// unless full refs are requested only branches are listed; other refs are
+		// looked up on demand
+		var prefixes []string
+		if !r.fullrefs {
+			prefixes = []string{"refs/heads/"}
+		}
+		m, err = r.repo.ListRefs(prefixes)
+		if nil == err {
+			r.storeRefs(meta, m, prefixes)
+		}
+	}
 	if nil != err {
+		// keep using the previous refs if they cannot be refreshed
+		r.lock.Lock()
+		if nil != r.refs {
+			r.refsTime = time.Now()
+			r.stale = false
+			err = fn(r.refs)
+		}
+		r.lock.Unlock()
 		return err
 	}
 
 	refs := make(map[string]*gitRef)
+	r.addRefs(refs, m)
+
+	r.lock.Lock()
+	if nil == r.refs || r.refsExpired() {
+		// refs that still point to the same commit keep their trees; temporary refs
+		// are kept because they are not listed by the server
+		for k, ref := range r.refs {
+			if n, ok := refs[k]; ok {
+				if n.kind == ref.kind && n.targetHash == ref.targetHash {
+					refs[k] = ref
+				}
+			} else if RefTemp == ref.kind {
+				refs[k] = ref
+			}
+		}
+		r.refs = refs
+		r.norefs = make(map[string]bool)
+		r.refsTime = time.Now()
+		r.stale = false
+	}
+	err = fn(r.refs)
+	r.lock.Unlock()
+	return err
+}
+
+// refsExpired determines if the refs must be read again. It must be called with the
+// lock held.
+func (r *gitRepository) refsExpired() bool {
+	return r.stale || (0 < r.refresh && r.refresh <= time.Since(r.refsTime))
+}
+
+// RefreshRefs marks the refs as stale, so that they are read again when next used.
+// Refs that are in use are not affected; a moved branch resolves to its new commit
+// on the next lookup.
+func (r *gitRepository) RefreshRefs() error {
+	r.lock.Lock()
+	if nil != r.refs {
+		r.stale = true
+	}
+	r.gitrefs = nil
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *gitRepository) addRefs(refs map[string]*gitRef, m map[string]string) {
 	for n, h := range m {
 		kind := RefOther
 		if strings.HasPrefix(n, "refs/heads/") {
@@ -402,14 +755,59 @@ func (r *gitRepository) ensureRefs(fn func(refs map[string]*gitRef) error) error
 			targetHash: h,
 		}
 	}
+}
+
+// lookupRef looks up a tag that was not included in the initial ref listing.
+func (r *gitRepository) lookupRef(k string, name string) (res Ref, err error) {
+	if r.fullrefs || r.offline {
+		return nil, ErrNotFound
+	}
+
+	err = r.ensureOpen()
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.RLock()
+	missing := r.norefs[k]
+	r.lock.RUnlock()
+	if missing {
+		return nil, ErrNotFound
+	}
+
+	prefix := "refs/tags/"
+	if !r.caseins {
+		// server filtering is case-sensitive; with case-insensitive names list all tags
+		prefix += strings.ReplaceAll(name, string(AltPathSeparator), "/")
+	}
+	m, err := r.repo.ListRefs([]string{prefix})
+	if nil != err {
+		return nil, err
+	}
+	r.lock.RLock()
+	meta := r.metadir()
+	r.lock.RUnlock()
+	r.storeRefs(meta, m, []string{prefix})
+
+	refs := make(map[string]*gitRef)
+	r.addRefs(refs, m)
 
 	r.lock.Lock()
-	if nil == r.refs {
-		r.refs = refs
+	ref, ok := r.refs[k]
+	if !ok {
+		ref, ok = refs[k]
+		if ok {
+			r.refs[k] = ref
+		} else {
+			r.norefs[k] = true
+		}
 	}
-	err = fn(r.refs)
 	r.lock.Unlock()
-	return err
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ref, nil
 }
 
 func (r *gitRepository) GetRefs() (res []Ref, err error) {
@@ -446,6 +844,9 @@ func (r *gitRepository) GetRef(name string) (res Ref, err error) {
 		}
 		return nil
 	})
+	if ErrNotFound == err {
+		res, err = r.lookupRef(k, name)
+	}
 	return
 }
 
@@ -460,6 +861,42 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 		k = strings.ToUpper(k)
 	}
 
+	r.lock.RLock()
+	dir := r.objdir()
+	meta := r.metadir()
+	r.lock.RUnlock()
+
+	// a commit already known from the metadata directory or present in the object
+	// cache needs no server access
+	var commit gitMetaCommit
+	known := readMeta(meta, "commits", strings.ToLower(name), &commit) && !commit.Tag
+	if !known && "" != dir {
+		p := objectPath(dir, strings.ToLower(name))
+		content, ot, _, e := readObject(p)
+		if nil == e && bytes.HasPrefix(content, []byte("tree ")) &&
+			r.checkObject(p, strings.ToLower(name), ot, bytes.NewReader(content), int64(len(content))) {
+			_, e = git.DecodeCommit(content)
+			known = nil == e
+		}
+	}
+	if known {
+		ref := &gitRef{
+			name:       strings.ToLower(name),
+			kind:       RefTemp,
+			targetHash: strings.ToLower(name),
+		}
+		r.lock.Lock()
+		if nil != r.refs {
+			if old, ok := r.refs[k]; ok {
+				ref = old
+			} else {
+				r.refs[k] = ref
+			}
+		}
+		r.lock.Unlock()
+		return ref, nil
+	}
+
 	err = r.ensureRefs(func(refs map[string]*gitRef) error {
 		var ok bool
 		res, ok = refs[k]
@@ -472,10 +909,6 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 		return
 	}
 
-	r.lock.RLock()
-	dir := r.dir
-	r.lock.RUnlock()
-
 	err = r.refetchObjects(dir, []string{name}, func(hash string, ot git.ObjectType) error {
 		if git.CommitObject != ot {
 			return ErrNotFound
@@ -500,11 +933,6 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 
 func (r *gitRepository) ensureTree(
 	ref0 Ref, entry0 TreeEntry, fn func(tree map[string]*gitTreeEntry) error) error {
-	r.once.Do(func() { r.open() })
-	if nil == r.repo {
-		return ErrNotFound
-	}
-
 	ref, _ := ref0.(*gitRef)
 	entry, ok := entry0.(*gitTreeEntry)
 	if ok && 0040000 != entry.entry.Mode {
@@ -525,12 +953,44 @@ func (r *gitRepository) ensureTree(
 			return err
 		}
 	}
-	dir := r.dir
+	dir := r.objdir()
+	meta := r.metadir()
 	r.lock.RUnlock()
 
-	var treeTime time.Time
-	want := []string{""}
+	done := func(tree map[string]*gitTreeEntry, treeTime time.Time) (err error) {
+		r.lock.Lock()
+		if nil == entry {
+			if nil == ref.tree {
+				ref.tree = tree
+				ref.treeTime = treeTime
+			}
+			err = fn(ref.tree)
+		} else {
+			if nil == entry.tree {
+				entry.tree = tree
+			}
+			err = fn(entry.tree)
+		}
+		r.lock.Unlock()
+		return
+	}
+
+	// trees are immutable; use the metadata directory to avoid contacting the server
+	var commit gitMetaCommit
 	if nil == entry {
+		readMeta(meta, "commits", ref.targetHash, &commit)
+	} else {
+		commit.Tree = entry.entry.Hash
+	}
+	if "" != commit.Tree {
+		if tree, ok := r.loadTree(meta, commit.Tree, entry); ok {
+			return done(tree, commit.Time)
+		}
+	}
+
+	treeTime := commit.Time
+	want := []string{commit.Tree}
+	if "" == commit.Tree {
 		h := ""
 		f := func(hash string, content []byte) error {
 			c, err := git.DecodeCommit(content)
@@ -558,9 +1018,13 @@ func (r *gitRepository) ensureTree(
 		if nil != err {
 			return err
 		}
-	} else {
-		want[0] = entry.entry.Hash
+		writeMeta(meta, "commits", ref.targetHash, &gitMetaCommit{
+			Tree: want[0],
+			Time: treeTime,
+			Tag:  "" != h,
+		})
 	}
+	treeHash := want[0]
 
 	tree := make(map[string]*gitTreeEntry)
 	err := r.fetchObjects(dir, want, func(hash string, content []byte) error {
@@ -582,6 +1046,34 @@ func (r *gitRepository) ensureTree(
 		return err
 	}
 
+	// LFS pointers are only looked for in files that match a filter=lfs rule of the
+	// .gitattributes files of the tree and its parent trees
+	tpath, inherited := "", []git.LfsRule(nil)
+	if nil != entry {
+		tpath, inherited = entry.path, entry.rules
+	}
+	rules := inherited
+	k := ".gitattributes"
+	if r.caseins {
+		k = strings.ToUpper(k)
+	}
+	if e, ok := tree[k]; ok && 0100000 == e.entry.Mode&0170000 {
+		err = r.fetchObjects(dir, []string{e.entry.Hash}, func(hash string, content []byte) error {
+			rules = append(inherited[:len(inherited):len(inherited)],
+				git.DecodeLfsRules(tpath, content)...)
+			return nil
+		})
+		if nil != err {
+			return err
+		}
+	}
+	for _, e := range tree {
+		if 0040000 == e.entry.Mode {
+			e.path = path.Join(tpath, e.entry.Name)
+			e.rules = rules
+		}
+	}
+
 	want = make([]string, 0, len(tree))
 	entm := make(map[string][]*gitTreeEntry, len(tree))
 	for _, e := range tree {
@@ -590,7 +1082,7 @@ func (r *gitRepository) ensureTree(
 			entm[e.entry.Hash] = append(entm[e.entry.Hash], e)
 		}
 	}
-	err = r.prefetchObjects(dir, want, func(hash string, size int64) error {
+	err = r.sizeObjects(dir, treeHash, want, func(hash string, size int64) error {
 		l, ok := entm[hash]
 		if ok {
 			for _, e := range l {
@@ -628,21 +1120,36 @@ func (r *gitRepository) ensureTree(
 		return err
 	}
 
-	r.lock.Lock()
-	if nil == entry {
-		if nil == ref.tree {
-			ref.tree = tree
-			ref.treeTime = treeTime
+	if 0 < len(rules) {
+		want = make([]string, 0, len(tree))
+		entm = make(map[string][]*gitTreeEntry, len(tree))
+		for _, e := range tree {
+			if 0100000 == e.entry.Mode&0170000 && git.LfsPointerMaxSize >= e.size &&
+				git.MatchLfsRules(rules, path.Join(tpath, e.entry.Name)) {
+				want = append(want, e.entry.Hash)
+				entm[e.entry.Hash] = append(entm[e.entry.Hash], e)
+			}
 		}
-		err = fn(ref.tree)
-	} else {
-		if nil == entry.tree {
-			entry.tree = tree
+		err = r.fetchObjects(dir, want, func(hash string, content []byte) error {
+			l, ok := entm[hash]
+			if ok {
+				if p, ok := git.DecodeLfsPointer(content); ok {
+					for _, e := range l {
+						e.pointer = p
+						e.size = p.Size
+					}
+				}
+			}
+			return nil
+		})
+		if nil != err {
+			return err
 		}
-		err = fn(entry.tree)
 	}
-	r.lock.Unlock()
-	return err
+
+	r.storeTree(meta, treeHash, tpath, len(inherited), rules, tree)
+
+	return done(tree, treeTime)
 }
 
 func (r *gitRepository) GetTree(ref Ref, entry TreeEntry) (res []TreeEntry, err error) {
@@ -676,15 +1183,14 @@ func (r *gitRepository) GetTreeEntry(ref Ref, entry TreeEntry, name string) (res
 }
 
 func (r *gitRepository) GetBlobReader(entry TreeEntry) (res io.ReaderAt, err error) {
-	r.once.Do(func() { r.open() })
-	if nil == r.repo {
-		return nil, ErrNotFound
-	}
-
 	r.lock.RLock()
-	dir := r.dir
+	dir := r.objdir()
 	r.lock.RUnlock()
 
+	if e, ok := entry.(*gitTreeEntry); ok && nil != e.pointer {
+		return r.getLfsReader(dir, e.pointer)
+	}
+
 	want := []string{entry.Hash()}
 	err = r.fetchReaders(dir, want, func(hash string, reader io.ReaderAt) error {
 		res = reader
@@ -693,13 +1199,101 @@ func (r *gitRepository) GetBlobReader(entry TreeEntry) (res io.ReaderAt, err err
 	return
 }
 
-func (r *gitRepository) ensureModules(
-	ref0 Ref, fn func(modules map[string]string) error) error {
-	r.once.Do(func() { r.open() })
-	if nil == r.repo {
-		return ErrNotFound
+// prefetchBlobs fetches the content of blobs into the cache. The blobs that are not
+// already cached are fetched with a single request; LFS objects are fetched individually.
+func (r *gitRepository) prefetchBlobs(entries []TreeEntry, fn func(i int, err error)) {
+	r.lock.RLock()
+	dir := r.objdir()
+	r.lock.RUnlock()
+
+	index := make(map[string][]int, len(entries))
+	want := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		if e, ok := entry.(*gitTreeEntry); ok && nil != e.pointer {
+			reader, err := r.getLfsReader(dir, e.pointer)
+			if c, ok := reader.(io.Closer); ok {
+				c.Close()
+			}
+			fn(i, err)
+			continue
+		}
+		hash := entry.Hash()
+		if _, ok := index[hash]; !ok {
+			want = append(want, hash)
+		}
+		index[hash] = append(index[hash], i)
+	}
+
+	err := r.fetchReaders(dir, want, func(hash string, reader io.ReaderAt) error {
+		if c, ok := reader.(io.Closer); ok {
+			c.Close()
+		}
+		for _, i := range index[hash] {
+			fn(i, nil)
+		}
+		delete(index, hash)
+		return nil
+	})
+	if nil == err {
+		err = ErrNotFound
+	}
+	for _, l := range index {
+		for _, i := range l {
+			fn(i, err)
+		}
+	}
+}
+
+// getLfsReader returns a reader for the content of an LFS object. LFS objects are
+// fetched on demand and stored alongside the git objects.
+func (r *gitRepository) getLfsReader(dir string, pointer *git.LfsPointer) (res io.ReaderAt, err error) {
+	if r.local {
+		// local repositories keep LFS objects in the git directory
+		uri, err := url.Parse(r.remote)
+		if nil != err {
+			return nil, err
+		}
+		gitdir, ok := git.IsLocalRepository(localPath(uri))
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return os.Open(git.LfsObjectPath(filepath.Join(gitdir, "lfs", "objects"), pointer.Oid))
+	}
+
+	dir, err = r.blobdir(dir)
+	if nil != err {
+		return nil, err
+	}
+
+	lfsdir := filepath.Join(dir, "lfs", "objects")
+	p := git.LfsObjectPath(lfsdir, pointer.Oid)
+	file, err := r.objcache.openLfsObject(p)
+	if nil == err {
+		if r.checkLfsObject(p, pointer, file) {
+			return file, nil
+		}
+		file.Close()
 	}
+	if r.offline {
+		return nil, errOffline
+	}
+
+	err = git.FetchLfsObject(r.remote, r.username, r.password, pointer, lfsdir)
+	if nil != err {
+		return nil, err
+	}
+	// open (and pin) before storing, so that the object cannot be evicted
+	file, err = r.objcache.openLfsObject(p)
+	if nil != err {
+		return nil, err
+	}
+	r.storeObject(dir, p, pointer.Size)
+
+	return file, nil
+}
 
+func (r *gitRepository) ensureModules(
+	ref0 Ref, fn func(modules map[string]string) error) error {
 	ref, _ := ref0.(*gitRef)
 
 	r.lock.RLock()
@@ -762,11 +1356,12 @@ func (r *gitRepository) GetModule(ref Ref, path string, rootrel bool) (res strin
 			return ErrNotFound
 		}
 		if rootrel {
-			u0, e0 := url.Parse(r.remote)
-			u1, e1 := url.Parse(res)
+			u0, e0 := ParseRemote(r.remote)
+			u1, e1 := ParseRemote(res)
 			if nil == e0 && nil == e1 {
-				if u0.Scheme == u1.Scheme && u0.Host == u1.Host {
-					res = strings.TrimSuffix(u1.Path, ".git")
+				if (u0.Scheme == u1.Scheme && u0.Host == u1.Host) ||
+					(("ssh" == u0.Scheme || "ssh" == u1.Scheme) && u0.Hostname() == u1.Hostname()) {
+					res = "/" + strings.TrimPrefix(strings.TrimSuffix(u1.Path, ".git"), "/")
 				}
 			}
 		}
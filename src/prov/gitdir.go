@@ -0,0 +1,167 @@
+/*
+ * gitdir.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+// gitRefPrefixes are the refs that are included in a GitDir.
+var gitRefPrefixes = []string{"refs/heads/", "refs/tags/"}
+
+// ensureGitRefs returns all branches and tags. Unlike the refs used for ref directories
+// (which by default include only branches) tags are listed too, because tools such as
+// git describe need them. They are listed again when the refs are refreshed.
+func (r *gitRepository) ensureGitRefs() (res map[string]string, err error) {
+	r.lock.RLock()
+	res = r.gitrefs
+	if nil != res && (0 == r.refresh || r.refresh > time.Since(r.gitTime)) {
+		r.lock.RUnlock()
+		return
+	}
+	meta := r.metadir()
+	r.lock.RUnlock()
+
+	var m map[string]string
+	if r.offline {
+		var ok bool
+		m, ok = r.loadRefs(meta)
+		if !ok {
+			return nil, ErrNotFound
+		}
+	} else {
+		err = r.ensureOpen()
+		if nil != err {
+			return
+		}
+		m, err = r.repo.ListRefs(gitRefPrefixes)
+		if nil != err {
+			return
+		}
+		r.storeRefs(meta, m, gitRefPrefixes)
+	}
+
+	res = make(map[string]string, len(m))
+	for n, h := range m {
+		if hasPrefix(n, gitRefPrefixes) {
+			res[n] = h
+		}
+	}
+
+	r.lock.Lock()
+	r.gitrefs = res
+	r.gitTime = time.Now()
+	r.lock.Unlock()
+
+	return
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGitDir returns the git repository state of a ref. The HEAD of a branch is the
+// branch itself; the HEAD of any other ref is its commit (a detached HEAD).
+func (r *gitRepository) GetGitDir(ref0 Ref) (res *GitDir, err error) {
+	ref, ok := ref0.(*gitRef)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	refs, err := r.ensureGitRefs()
+	if nil != err {
+		return
+	}
+
+	// the commit of an annotated tag is the target of the tag object
+	commit := ref.targetHash
+	for i := 0; 8 > i; i++ {
+		kind, content, e := r.GetObject(commit)
+		if nil != e {
+			return nil, e
+		}
+		if "tag" != kind {
+			break
+		}
+		t, e := git.DecodeTag(content)
+		if nil != e {
+			return nil, e
+		}
+		commit = t.TargetHash
+	}
+
+	head := commit
+	if RefBranch == ref.kind {
+		name := strings.ReplaceAll(ref.name, string(AltPathSeparator), "/")
+		if !r.fullrefs {
+			name = "refs/heads/" + name
+		}
+		if h, ok := refs[name]; ok && h == ref.targetHash {
+			head = name
+		}
+	}
+
+	return &GitDir{
+		Remote: r.remote,
+		Head:   head,
+		Commit: commit,
+		Refs:   refs,
+	}, nil
+}
+
+// GetObject returns the type (e.g. "blob") and content of an object.
+func (r *gitRepository) GetObject(hash string) (kind string, content []byte, err error) {
+	r.lock.RLock()
+	dir := r.objdir()
+	r.lock.RUnlock()
+
+	var ot git.ObjectType
+	err = r.fetchReaders(dir, []string{hash}, func(hash string, reader io.ReaderAt) error {
+		file := reader.(*cacheFile)
+		defer file.Close()
+		ot = file.Type()
+		content = make([]byte, file.Size())
+		_, err := file.ReadAt(content, 0)
+		if io.EOF == err {
+			err = nil
+		}
+		return err
+	})
+	if nil != err {
+		return
+	}
+	if nil == content {
+		return "", nil, ErrNotFound
+	}
+
+	// object files of earlier releases do not record the object type
+	if 0 == ot {
+		var ok bool
+		ot, ok = git.ObjectTypeOf(bytes.NewReader(content), int64(len(content)), hash)
+		if !ok {
+			return "", nil, errCorrupted
+		}
+	}
+	return ot.String(), content, nil
+}
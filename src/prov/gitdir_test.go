@@ -0,0 +1,109 @@
+/*
+ * gitdir_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+func TestGitDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "gitdir_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, nil)
+
+	r := newGitRepository("https://example.com/owner/repo", "", "", false, false)
+	r.once.Do(func() {
+		repo, err := git.OpenLocalRepository(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		r.repo = repo
+	})
+	r.meta = filepath.Join(root, "meta")
+	err = r.SetDirectory(filepath.Join(root, "cache"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ref, err := r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	commit := ref.(*gitRef).targetHash
+
+	gitdir, err := r.GetGitDir(ref)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "https://example.com/owner/repo" != gitdir.Remote ||
+		"refs/heads/master" != gitdir.Head ||
+		commit != gitdir.Commit ||
+		commit != gitdir.Refs["refs/heads/master"] ||
+		commit != gitdir.Refs["refs/tags/v1.0"] {
+		t.Error(gitdir)
+	}
+
+	// any other ref has a detached HEAD
+	ref, err = r.GetTempRef(commit)
+	if nil != err {
+		t.Fatal(err)
+	}
+	gitdir, err = r.GetGitDir(ref)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if commit != gitdir.Head {
+		t.Error(gitdir)
+	}
+
+	kind, content, err := r.GetObject(commit)
+	if nil != err {
+		t.Fatal(err)
+	}
+	c, err := git.DecodeCommit(content)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "commit" != kind {
+		t.Error(kind)
+	}
+	kind, _, err = r.GetObject(c.TreeHash)
+	if nil != err || "tree" != kind {
+		t.Error(kind, err)
+	}
+	entry, err := r.GetTreeEntry(ref, nil, "README")
+	if nil != err {
+		t.Fatal(err)
+	}
+	kind, content, err = r.GetObject(entry.Hash())
+	if nil != err || "blob" != kind || localFileContent != string(content) {
+		t.Error(kind, err)
+	}
+
+	_, _, err = r.GetObject("0000000000000000000000000000000000000000")
+	if nil == err {
+		t.Error()
+	}
+}
@@ -0,0 +1,338 @@
+/*
+ * gitea.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cli/oauth"
+	"github.com/winfsp/hubfs/httputil"
+)
+
+type GiteaProvider struct {
+	Hostname     string
+	ClientId     string
+	ClientSecret string
+	CallbackURI  string
+	Scopes       string
+	ApiURI       string
+}
+
+// NewGiteaProvider creates a provider for the Gitea or Forgejo instance named by the
+// host part of the URI. For example: gitea://git.example.com/owner/repo.
+func NewGiteaProvider(uri *url.URL) Provider {
+	return newGiteaProvider(uri.Host, nil)
+}
+
+func newGiteaProvider(hostname string, config map[string]string) Provider {
+	return &GiteaProvider{
+		Hostname:     hostname,
+		ClientId:     providerConfigValue(config, "clientid", ""),
+		ClientSecret: providerConfigValue(config, "clientsecret", ""),
+		CallbackURI:  providerConfigValue(config, "callback", "http://127.0.0.1/callback"),
+		Scopes:       providerConfigValue(config, "scopes", ""),
+		ApiURI:       providerConfigValue(config, "api", "https://"+hostname+"/api/v1"),
+	}
+}
+
+func NewCodebergOrgProvider(uri *url.URL) Provider {
+	return newGiteaProvider("codeberg.org", nil)
+}
+
+func init() {
+	help := "" +
+		"%s://host[/owner[/repo]]\n" +
+		"    \taccess Gitea or Forgejo instance at host\n" +
+		"    \t- owner     file system root is at owner\n" +
+		"    \t- repo      file system root is at owner/repo"
+	RegisterProviderClass("gitea:", NewGiteaProvider, fmt.Sprintf(help, "gitea"))
+	RegisterProviderClass("forgejo:", NewGiteaProvider, fmt.Sprintf(help, "forgejo"))
+	RegisterProviderClass("codeberg.org", NewCodebergOrgProvider, ""+
+		"[https://]codeberg.org[/owner[/repo]]\n"+
+		"    \taccess codeberg.org\n"+
+		"    \t- owner     file system root is at owner\n"+
+		"    \t- repo      file system root is at owner/repo")
+	RegisterProviderKind("gitea", newGiteaProvider)
+	RegisterProviderKind("forgejo", newGiteaProvider)
+}
+
+func (p *GiteaProvider) Auth() (token string, err error) {
+	if "" == p.ClientId {
+		return "", errors.New(
+			p.Hostname + ": no OAuth client configured; use -auth token=T with an access token")
+	}
+
+	var scopes []string
+	if "" != p.Scopes {
+		scopes = strings.Split(p.Scopes, ",")
+	}
+	flow := &oauth.Flow{
+		Host: &oauth.Host{
+			AuthorizeURL: fmt.Sprintf("https://%s/login/oauth/authorize", p.Hostname),
+			TokenURL:     fmt.Sprintf("https://%s/login/oauth/access_token", p.Hostname),
+		},
+		ClientID:     p.ClientId,
+		ClientSecret: p.ClientSecret,
+		CallbackURI:  p.CallbackURI,
+		Scopes:       scopes,
+		HTTPClient:   httputil.DefaultClient,
+	}
+	accessToken, err := flow.WebAppFlow()
+	if nil != accessToken {
+		token = accessToken.Token
+	}
+	return
+}
+
+func (p *GiteaProvider) NewClient(token string) (Client, error) {
+	return NewGiteaClient(p.ApiURI, token)
+}
+
+type giteaClient struct {
+	client
+	httpClient *http.Client
+	ident      string
+	apiURI     string
+	token      string
+	login      string
+}
+
+func NewGiteaClient(apiURI string, token string) (Client, error) {
+	uri, err := url.Parse(apiURI)
+	if nil != err {
+		return nil, err
+	}
+
+	c := &giteaClient{
+		httpClient: httputil.DefaultClient,
+		ident:      uri.Hostname(),
+		apiURI:     apiURI,
+		token:      token,
+	}
+	c.client.init(c)
+
+	if "" != c.token {
+		rsp, err := c.sendrecv("/user")
+		if nil != err {
+			return nil, err
+		}
+		defer rsp.Body.Close()
+
+		var content struct {
+			Login string `json:"login"`
+		}
+		err = json.NewDecoder(rsp.Body).Decode(&content)
+		if nil != err {
+			return nil, err
+		}
+
+		c.login = content.Login
+	}
+
+	return c, nil
+}
+
+func (c *giteaClient) getIdent() string {
+	return c.ident
+}
+
+func (c *giteaClient) getGitCredentials() (string, string) {
+	if "" == c.token {
+		return "", ""
+	}
+	username := c.login
+	if "" == username {
+		username = "oauth2"
+	}
+	return username, c.token
+}
+
+func (c *giteaClient) sendrecv(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.apiURI+path, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if "" != c.token {
+		req.Header.Set("Authorization", "token "+c.token)
+	}
+
+	rsp, err := c.httpClient.Do(req)
+	if nil != err {
+		return nil, err
+	}
+
+	if 404 == rsp.StatusCode {
+		rsp.Body.Close()
+		return nil, ErrNotFound
+	} else if 400 <= rsp.StatusCode {
+		rsp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+	}
+
+	return rsp, nil
+}
+
+func (c *giteaClient) getOrg(o string) (res *owner, err error) {
+	defer trace(o)(&err)
+
+	rsp, err := c.sendrecv(fmt.Sprintf("/orgs/%s", url.PathEscape(o)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		FName string `json:"username"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+	if "" == content.FName {
+		return nil, ErrNotFound
+	}
+
+	res = &owner{
+		FName: content.FName,
+		FKind: "org",
+	}
+	res.Value = res
+	return
+}
+
+func (c *giteaClient) getUser(o string) (res *owner, err error) {
+	defer trace(o)(&err)
+
+	rsp, err := c.sendrecv(fmt.Sprintf("/users/%s", url.PathEscape(o)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		FName string `json:"login"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = &owner{
+		FName: content.FName,
+		FKind: "user",
+	}
+	res.Value = res
+	return
+}
+
+func (c *giteaClient) getOwner(o string) (res *owner, err error) {
+	res, err = c.getOrg(o)
+	if ErrNotFound != err {
+		return
+	}
+	res, err = c.getUser(o)
+	return
+}
+
+func (c *giteaClient) getRepositoryPage(owner string, path string) ([]*repository, int, int, error) {
+	rsp, err := c.sendrecv(path)
+	if nil != err {
+		return nil, 0, -1, err
+	}
+	defer rsp.Body.Close()
+
+	total := -1
+	if s := rsp.Header.Get("X-Total-Count"); "" != s {
+		if n, e := strconv.Atoi(s); nil == e {
+			total = n
+		}
+	}
+
+	var content []struct {
+		FName      string `json:"name"`
+		FRemote    string `json:"clone_url"`
+		FSshRemote string `json:"ssh_url"`
+		Owner      struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, 0, -1, err
+	}
+
+	res := make([]*repository, 0, len(content))
+	for _, elm := range content {
+		if !strings.EqualFold(owner, elm.Owner.Login) {
+			// the authenticated user's listing includes repositories of other owners
+			continue
+		}
+		r := &repository{
+			FName:      elm.FName,
+			FRemote:    elm.FRemote,
+			FSshRemote: elm.FSshRemote,
+		}
+		r.Value = r
+		r.Repository = emptyRepository
+		r.keepdir = c.keepdir
+		res = append(res, r)
+	}
+
+	return res, len(content), total, nil
+}
+
+func (c *giteaClient) getRepositories(owner string, kind string) (res []*repository, err error) {
+	defer trace(owner)(&err)
+
+	const limit = 50
+
+	var path string
+	if "org" == kind {
+		path = fmt.Sprintf("/orgs/%s/repos?limit=%d", url.PathEscape(owner), limit)
+	} else if c.login == owner {
+		path = fmt.Sprintf("/user/repos?limit=%d", limit)
+	} else {
+		path = fmt.Sprintf("/users/%s/repos?limit=%d", url.PathEscape(owner), limit)
+	}
+
+	res = make([]*repository, 0)
+	for page, count := 1, 0; ; page++ {
+		lst, n, total, err := c.getRepositoryPage(owner, path+fmt.Sprintf("&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+		count += n
+		if 0 == n || (0 <= total && count >= total) || (0 > total && n < limit) {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *giteaClient) getTreeSizes(owner string, repo string, tree string) (
+	res map[string]int64, err error) {
+	defer trace(owner, repo, tree)(&err)
+
+	return getTreeSizes(c.sendrecv, "/repos/%s/%s/git/trees/%s", owner, repo, tree)
+}
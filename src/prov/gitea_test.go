@@ -0,0 +1,147 @@
+/*
+ * gitea_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newGiteaTestServer(t *testing.T) *httptest.Server {
+	repos := func(owner string, first int, count int) string {
+		lst := make([]string, 0, count)
+		for i := first; first+count > i; i++ {
+			lst = append(lst, fmt.Sprintf(`{"name":"repo%d",`+
+				`"clone_url":"https://gitea.example/%s/repo%d.git",`+
+				`"ssh_url":"git@gitea.example:%s/repo%d.git",`+
+				`"owner":{"login":%q}}`, i, owner, i, owner, i, owner))
+		}
+		return "[" + strings.Join(lst, ",") + "]"
+	}
+	page := func(req *http.Request) int {
+		n, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		return n
+	}
+
+	auth := func(w http.ResponseWriter, req *http.Request) bool {
+		if auth := req.Header.Get("Authorization"); "" != auth && "token secret" != auth {
+			w.WriteHeader(401)
+			return false
+		}
+		return true
+	}
+
+	return newTestApiServer(auth, map[string]http.HandlerFunc{
+		"/api/v1/user": func(w http.ResponseWriter, req *http.Request) {
+			if "" == req.Header.Get("Authorization") {
+				w.WriteHeader(401)
+				return
+			}
+			fmt.Fprint(w, `{"login":"me"}`)
+		},
+		"/api/v1/orgs/org":    testReply(`{"username":"org"}`),
+		"/api/v1/users/me":    testReply(`{"login":"me"}`),
+		"/api/v1/users/other": testReply(`{"login":"other"}`),
+		"/api/v1/orgs/org/repos": func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Total-Count", "52")
+			if 1 == page(req) {
+				fmt.Fprint(w, repos("org", 0, 50))
+			} else {
+				fmt.Fprint(w, repos("org", 50, 2))
+			}
+		},
+		"/api/v1/user/repos": func(w http.ResponseWriter, req *http.Request) {
+			/* the listing of the authenticated user includes repositories of other owners */
+			w.Header().Set("X-Total-Count", "2")
+			fmt.Fprint(w, strings.TrimSuffix(repos("me", 0, 1), "]")+","+
+				strings.TrimPrefix(repos("org", 0, 1), "["))
+		},
+		"/api/v1/users/other/repos": func(w http.ResponseWriter, req *http.Request) {
+			/* no total count; the last page is short */
+			if 1 == page(req) {
+				fmt.Fprint(w, repos("other", 0, 3))
+			} else {
+				fmt.Fprint(w, "[]")
+			}
+		},
+		"/api/v1/repos/me/repo0/git/trees/1111": testReply(`{"sha":"1111","truncated":true,"tree":[` +
+			`{"path":"file","type":"blob","sha":"2222","size":42},` +
+			`{"path":"dir","type":"tree","sha":"3333"}]}`),
+	})
+}
+
+func TestGiteaClient(t *testing.T) {
+	server := newGiteaTestServer(t)
+	defer server.Close()
+
+	_, err := NewGiteaClient(server.URL+"/api/v1", "wrongsecret")
+	if nil == err {
+		t.Error()
+	}
+
+	c, err := NewGiteaClient(server.URL+"/api/v1", "secret")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if username, password := c.(*giteaClient).getGitCredentials(); "me" != username ||
+		"secret" != password {
+		t.Error(username, password)
+	}
+
+	expect := func(name string, ecount int) {
+		owner, err := c.OpenOwner(name)
+		if nil != err {
+			t.Fatal(err)
+		}
+		defer c.CloseOwner(owner)
+		repositories, err := c.GetRepositories(owner)
+		if nil != err {
+			t.Error(err)
+		}
+		if ecount != len(repositories) {
+			t.Errorf("owner %q expect %d repositories got %d", name, ecount, len(repositories))
+		}
+		for _, r := range repositories {
+			if "https://gitea.example/"+name+"/"+r.Name()+".git" != r.(*repository).FRemote {
+				t.Error(r.(*repository).FRemote)
+			}
+		}
+	}
+
+	expect("org", 52)
+	expect("me", 1)
+	expect("other", 3)
+
+	_, err = c.OpenOwner("nonexistent")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+
+	sizes, err := c.(*giteaClient).getTreeSizes("me", "repo0", "1111")
+	if nil != err {
+		t.Error(err)
+	}
+	if 1 != len(sizes) || 42 != sizes["2222"] {
+		t.Error(sizes)
+	}
+
+	_, err = c.(*giteaClient).getTreeSizes("me", "nonexistent", "1111")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+}
@@ -47,15 +47,32 @@ func NewGithubComProvider(uri *url.URL) Provider {
 	}
 }
 
+func newGithubProvider(hostname string, config map[string]string) Provider {
+	return &GithubProvider{
+		Hostname:     hostname,
+		ClientId:     providerConfigValue(config, "clientid", ""),
+		ClientSecret: providerConfigValue(config, "clientsecret", "ClientSecret"),
+		CallbackURI:  providerConfigValue(config, "callback", "http://127.0.0.1/callback"),
+		Scopes:       providerConfigValue(config, "scopes", "repo"),
+		ApiURI:       providerConfigValue(config, "api", "https://"+hostname+"/api/v3"),
+	}
+}
+
 func init() {
 	RegisterProviderClass("github.com", NewGithubComProvider, ""+
 		"[https://]github.com[/owner[/repo]]\n"+
 		"    \taccess github.com\n"+
 		"    \t- owner     file system root is at owner\n"+
 		"    \t- repo      file system root is at owner/repo")
+	RegisterProviderKind("github", newGithubProvider)
 }
 
 func (p *GithubProvider) Auth() (token string, err error) {
+	if "" == p.ClientId {
+		return "", errors.New(
+			p.Hostname + ": no OAuth client configured; use -auth token=T with an access token")
+	}
+
 	flow := &oauth.Flow{
 		Host:         oauth.GitHubHost("https://" + p.Hostname),
 		ClientID:     p.ClientId,
@@ -228,8 +245,9 @@ func (c *githubClient) getRepositoryPageRest(path string) ([]*repository, error)
 	defer rsp.Body.Close()
 
 	var content []struct {
-		FName   string `json:"name"`
-		FRemote string `json:"clone_url"`
+		FName      string `json:"name"`
+		FRemote    string `json:"clone_url"`
+		FSshRemote string `json:"ssh_url"`
 	}
 	err = json.NewDecoder(rsp.Body).Decode(&content)
 	if nil != err {
@@ -239,8 +257,9 @@ func (c *githubClient) getRepositoryPageRest(path string) ([]*repository, error)
 	res := make([]*repository, len(content))
 	for i, elm := range content {
 		r := &repository{
-			FName:   elm.FName,
-			FRemote: elm.FRemote,
+			FName:      elm.FName,
+			FRemote:    elm.FRemote,
+			FSshRemote: elm.FSshRemote,
 		}
 		r.Value = r
 		r.Repository = emptyRepository
@@ -294,8 +313,9 @@ func (c *githubClient) getRepositoryPageGql(query string) ([]*repository, string
 						EndCursor   string `json:"endCursor"`
 					} `json:"pageInfo"`
 					Nodes []struct {
-						FName   string `json:"name"`
-						FRemote string `json:"url"`
+						FName      string `json:"name"`
+						FRemote    string `json:"url"`
+						FSshRemote string `json:"sshUrl"`
 					} `json:"nodes"`
 				} `json:"repositories"`
 			} `json:"owner"`
@@ -315,8 +335,9 @@ func (c *githubClient) getRepositoryPageGql(query string) ([]*repository, string
 	res := make([]*repository, len(content.Data.Owner.Repositories.Nodes))
 	for i, elm := range content.Data.Owner.Repositories.Nodes {
 		r := &repository{
-			FName:   elm.FName,
-			FRemote: elm.FRemote,
+			FName:      elm.FName,
+			FRemote:    elm.FRemote,
+			FSshRemote: elm.FSshRemote,
 		}
 		r.Value = r
 		r.Repository = emptyRepository
@@ -345,6 +366,7 @@ func (c *githubClient) getRepositoriesGql(owner string, kind string) (res []*rep
 				nodes {
 					name
 					url
+					sshUrl
 				}
 			}
 		}
@@ -409,3 +431,10 @@ func (c *githubClient) getRepositories(owner string, kind string) (res []*reposi
 	}
 	return c.getRepositoriesRest(owner, kind)
 }
+
+func (c *githubClient) getTreeSizes(owner string, repo string, tree string) (
+	res map[string]int64, err error) {
+	defer trace(owner, repo, tree)(&err)
+
+	return getTreeSizes(c.sendrecv, "/repos/%s/%s/git/trees/%s", owner, repo, tree)
+}
@@ -49,12 +49,24 @@ func NewGitlabComProvider(uri *url.URL) Provider {
 	}
 }
 
+func newGitlabProvider(hostname string, config map[string]string) Provider {
+	return &GitlabProvider{
+		Hostname:     hostname,
+		ClientId:     providerConfigValue(config, "clientid", ""),
+		ClientSecret: providerConfigValue(config, "clientsecret", "ClientSecret"),
+		CallbackURI:  providerConfigValue(config, "callback", "http://127.0.0.1/callback"),
+		Scopes:       providerConfigValue(config, "scopes", "read_api,read_user,read_repository"),
+		ApiURI:       providerConfigValue(config, "api", "https://"+hostname+"/api/v4"),
+	}
+}
+
 func init() {
 	RegisterProviderClass("gitlab.com", NewGitlabComProvider, ""+
 		"[https://]gitlab.com[/owner[/repo]]\n"+
 		"    \taccess gitlab.com\n"+
 		"    \t- owner     file system root is at owner\n"+
 		"    \t- repo      file system root is at owner/repo")
+	RegisterProviderKind("gitlab", newGitlabProvider)
 }
 
 type gitlabWebAppFlowHttpClient struct {
@@ -72,6 +84,11 @@ func (c *gitlabWebAppFlowHttpClient) PostForm(url string, data url.Values) (*htt
 }
 
 func (p *GitlabProvider) Auth() (token string, err error) {
+	if "" == p.ClientId {
+		return "", errors.New(
+			p.Hostname + ": no OAuth client configured; use -auth token=T with an access token")
+	}
+
 	// PKCE (RFC 7636) for GitLab
 	buf := make([]byte, 80)
 	_, err = rand.Read(buf)
@@ -261,8 +278,9 @@ func (c *gitlabClient) getRepositoryPage(prefix string, path string) ([]*reposit
 	defer rsp.Body.Close()
 
 	var content []struct {
-		FName   string `json:"path_with_namespace"`
-		FRemote string `json:"http_url_to_repo"`
+		FName      string `json:"path_with_namespace"`
+		FRemote    string `json:"http_url_to_repo"`
+		FSshRemote string `json:"ssh_url_to_repo"`
 	}
 	err = json.NewDecoder(rsp.Body).Decode(&content)
 	if nil != err {
@@ -275,8 +293,9 @@ func (c *gitlabClient) getRepositoryPage(prefix string, path string) ([]*reposit
 		n = strings.TrimPrefix(n, prefix)
 		n = strings.ReplaceAll(n, "/", string(AltPathSeparator))
 		r := &repository{
-			FName:   n,
-			FRemote: elm.FRemote,
+			FName:      n,
+			FRemote:    elm.FRemote,
+			FSshRemote: elm.FSshRemote,
 		}
 		r.Value = r
 		r.Repository = emptyRepository
@@ -0,0 +1,236 @@
+/*
+ * gitmeta.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+// Metadata derived from immutable objects (the tree of a commit, the decoded listing
+// of a tree) is persisted in a metadata directory that is kept across mounts. It is
+// keyed by object hash and is therefore never invalidated. The last known refs of a
+// repository are also kept there for use in offline mode.
+
+type gitMetaCommit struct {
+	Tree string    `json:"tree"`
+	Time time.Time `json:"time"`
+	Tag  bool      `json:"tag,omitempty"`
+}
+
+// gitMetaTreeVersion is the version of the stored tree format; trees stored with other
+// versions are ignored.
+const gitMetaTreeVersion = 1
+
+type gitMetaTree struct {
+	Version int              `json:"version"`
+	Path    string           `json:"path,omitempty"`
+	Rules   []git.LfsRule    `json:"rules,omitempty"`
+	Inherit int              `json:"inherit,omitempty"`
+	Entries []gitMetaTreeEnt `json:"entries"`
+}
+
+type gitMetaTreeEnt struct {
+	Name    string `json:"name"`
+	Mode    uint32 `json:"mode"`
+	Hash    string `json:"hash"`
+	Size    int64  `json:"size"`
+	Target  string `json:"target,omitempty"`
+	LfsOid  string `json:"lfsoid,omitempty"`
+	LfsSize int64  `json:"lfssize,omitempty"`
+}
+
+func metaPath(dir string, kind string, hash string) string {
+	if "" == dir || 2 >= len(hash) {
+		return ""
+	}
+	return filepath.Join(dir, kind, hash[:2], hash[2:])
+}
+
+func readMeta(dir string, kind string, hash string, v interface{}) bool {
+	return readMetaFile(metaPath(dir, kind, hash), v)
+}
+
+func writeMeta(dir string, kind string, hash string, v interface{}) {
+	writeMetaFile(metaPath(dir, kind, hash), v)
+}
+
+func readMetaFile(p string, v interface{}) bool {
+	if "" == p {
+		return false
+	}
+	content, err := ioutil.ReadFile(p)
+	if nil != err {
+		return false
+	}
+	return nil == json.Unmarshal(content, v)
+}
+
+func writeMetaFile(p string, v interface{}) {
+	if "" == p {
+		return
+	}
+	content, err := json.Marshal(v)
+	if nil != err {
+		return
+	}
+	if nil == os.MkdirAll(filepath.Dir(p), 0700) {
+		file, err := ioutil.TempFile(filepath.Dir(p), ".tmp")
+		if nil != err {
+			return
+		}
+		_, err = file.Write(content)
+		if e := file.Close(); nil == err {
+			err = e
+		}
+		if nil == err {
+			err = os.Rename(file.Name(), p)
+		}
+		if nil != err {
+			os.Remove(file.Name())
+		}
+	}
+}
+
+// loadTree loads a decoded tree from the metadata directory. A tree is only loaded if
+// it was stored with the same LFS rules inherited from its parent trees and (if there
+// are any rules) at the same path.
+func (r *gitRepository) loadTree(meta string, hash string, entry *gitTreeEntry) (
+	tree map[string]*gitTreeEntry, ok bool) {
+	var t gitMetaTree
+	if !readMeta(meta, "trees", hash, &t) || gitMetaTreeVersion != t.Version {
+		return nil, false
+	}
+	tpath, inherited := "", []git.LfsRule(nil)
+	if nil != entry {
+		tpath, inherited = entry.path, entry.rules
+	}
+	if len(inherited) != t.Inherit || t.Inherit > len(t.Rules) {
+		return nil, false
+	}
+	for i := range inherited {
+		if inherited[i] != t.Rules[i] {
+			return nil, false
+		}
+	}
+	if 0 < len(t.Rules) && tpath != t.Path {
+		return nil, false
+	}
+	if 0 == len(t.Rules) {
+		t.Path = tpath
+	}
+
+	tree = make(map[string]*gitTreeEntry, len(t.Entries))
+	for _, m := range t.Entries {
+		k := m.Name
+		if r.caseins {
+			k = strings.ToUpper(k)
+		}
+
+		e := &gitTreeEntry{
+			entry:  git.TreeEntry{Name: m.Name, Mode: m.Mode, Hash: m.Hash},
+			size:   m.Size,
+			target: m.Target,
+		}
+		if 0040000 == m.Mode {
+			e.path = path.Join(t.Path, m.Name)
+			e.rules = t.Rules
+		}
+		if "" != m.LfsOid {
+			e.pointer = &git.LfsPointer{Oid: m.LfsOid, Size: m.LfsSize}
+		}
+		tree[k] = e
+	}
+
+	return tree, true
+}
+
+// storeTree stores a decoded tree in the metadata directory.
+func (r *gitRepository) storeTree(meta string, hash string,
+	path string, inherit int, rules []git.LfsRule, tree map[string]*gitTreeEntry) {
+	if "" == meta {
+		return
+	}
+
+	t := gitMetaTree{
+		Version: gitMetaTreeVersion,
+		Path:    path,
+		Rules:   rules,
+		Inherit: inherit,
+		Entries: make([]gitMetaTreeEnt, 0, len(tree)),
+	}
+	for _, e := range tree {
+		m := gitMetaTreeEnt{
+			Name:   e.entry.Name,
+			Mode:   e.entry.Mode,
+			Hash:   e.entry.Hash,
+			Size:   e.size,
+			Target: e.target,
+		}
+		if nil != e.pointer {
+			m.LfsOid = e.pointer.Oid
+			m.LfsSize = e.pointer.Size
+		}
+		t.Entries = append(t.Entries, m)
+	}
+
+	writeMeta(meta, "trees", hash, &t)
+}
+
+func (r *gitRepository) refsPath(meta string) string {
+	if "" == meta {
+		return ""
+	}
+	return filepath.Join(meta, "refs.json")
+}
+
+// loadRefs loads the last known refs.
+func (r *gitRepository) loadRefs(meta string) (m map[string]string, ok bool) {
+	ok = readMetaFile(r.refsPath(meta), &m)
+	return
+}
+
+// storeRefs stores the refs m that were listed for prefixes (nil for all refs). Stored
+// refs that are outside the listed prefixes are retained.
+func (r *gitRepository) storeRefs(meta string, m map[string]string, prefixes []string) {
+	if nil != prefixes {
+		if old, ok := r.loadRefs(meta); ok {
+			res := make(map[string]string, len(m))
+			for n, h := range m {
+				res[n] = h
+			}
+			m = res
+			for n, h := range old {
+				listed := false
+				for _, p := range prefixes {
+					if strings.HasPrefix(n, p) {
+						listed = true
+						break
+					}
+				}
+				if _, ok := m[n]; !ok && !listed {
+					m[n] = h
+				}
+			}
+		}
+	}
+	writeMetaFile(r.refsPath(meta), m)
+}
@@ -0,0 +1,442 @@
+/*
+ * gitmeta_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+func TestGitMetadata(t *testing.T) {
+	root, err := ioutil.TempDir("", "gitmeta_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, nil)
+
+	// open a repository that is treated as remote, so that it uses the cache
+	open := func(online bool) *gitRepository {
+		r := newGitRepository("https://example.com/owner/repo", "", "", false, false)
+		r.once.Do(func() {
+			if online {
+				repo, err := git.OpenLocalRepository(path)
+				if nil != err {
+					t.Fatal(err)
+				}
+				r.repo = repo
+			}
+		})
+		r.meta = filepath.Join(root, "meta")
+		err := r.SetDirectory(filepath.Join(root, "cache"))
+		if nil != err {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := open(true)
+	ref, err := r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	commit := ref.(*gitRef).targetHash
+	entry, err := r.GetTreeEntry(ref, nil, "dir")
+	if nil != err {
+		t.Fatal(err)
+	}
+	entry, err = r.GetTreeEntry(ref, entry, "file")
+	if nil != err {
+		t.Fatal(err)
+	}
+	reader, err := r.GetBlobReader(entry)
+	if nil != err {
+		t.Fatal(err)
+	}
+	reader.(io.Closer).Close()
+	r.Close()
+
+	// the remote is not available; trees must come from the metadata directory
+	r = open(false)
+	defer r.Close()
+
+	_, err = r.GetRef("master")
+	if nil == err {
+		t.Error()
+	}
+
+	ref, err = r.GetTempRef(commit)
+	if nil != err {
+		t.Fatal(err)
+	}
+	tree, err := r.GetTree(ref, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(tree) || ref.TreeTime().IsZero() {
+		t.Error()
+	}
+	entry, err = r.GetTreeEntry(ref, nil, "dir")
+	if nil != err {
+		t.Fatal(err)
+	}
+	entry, err = r.GetTreeEntry(ref, entry, "file")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if int64(len(localFileContent)) != entry.Size() {
+		t.Error()
+	}
+
+	reader, err = r.GetBlobReader(entry)
+	if nil != err {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader.(io.Reader))
+	reader.(io.Closer).Close()
+	if localFileContent != string(content) {
+		t.Error()
+	}
+}
+
+func TestGitMetadataLfs(t *testing.T) {
+	root, err := ioutil.TempDir("", "gitmeta_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	pointer := "version https://git-lfs.github.com/spec/v1\n" +
+		"oid sha256:" + strings.Repeat("0", 64) + "\nsize 1000000\n"
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, map[string]string{
+		".gitattributes":     "/dir/*.bin filter=lfs\n",
+		"pointer.txt":        pointer,
+		"dir/.gitattributes": "*.txt filter=lfs\n",
+		"dir/large.txt":      pointer,
+		"dir/large.bin":      pointer,
+	})
+
+	open := func(online bool) *gitRepository {
+		r := newGitRepository("https://example.com/owner/repo", "", "", false, false)
+		r.once.Do(func() {
+			if online {
+				repo, err := git.OpenLocalRepository(path)
+				if nil != err {
+					t.Fatal(err)
+				}
+				r.repo = repo
+			}
+		})
+		r.meta = filepath.Join(root, "meta")
+		err := r.SetDirectory(filepath.Join(root, "cache"))
+		if nil != err {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	check := func(r *gitRepository, ref Ref) {
+		expect := func(entry TreeEntry, name string, size int64) {
+			e, err := r.GetTreeEntry(ref, entry, name)
+			if nil != err {
+				t.Fatal(err)
+			}
+			if size != e.Size() {
+				t.Errorf("%s: expect size %d got %d", name, size, e.Size())
+			}
+		}
+		dir, err := r.GetTreeEntry(ref, nil, "dir")
+		if nil != err {
+			t.Fatal(err)
+		}
+		expect(nil, "pointer.txt", int64(len(pointer)))
+		expect(dir, "large.txt", 1000000)
+		expect(dir, "large.bin", 1000000)
+	}
+
+	r := open(true)
+	ref, err := r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	commit := ref.(*gitRef).targetHash
+	check(r, ref)
+	r.Close()
+
+	// the rules of the parent trees must be restored with the trees
+	r = open(false)
+	defer r.Close()
+	ref, err = r.GetTempRef(commit)
+	if nil != err {
+		t.Fatal(err)
+	}
+	check(r, ref)
+}
+
+func TestGitOffline(t *testing.T) {
+	root, err := ioutil.TempDir("", "gitmeta_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, nil)
+
+	r := newGitRepository("https://example.com/owner/repo", "", "", false, false)
+	r.once.Do(func() {
+		repo, err := git.OpenLocalRepository(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		r.repo = repo
+	})
+	r.meta = filepath.Join(root, "meta")
+	ref, err := r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, err = r.GetTree(ref, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, err = r.GetRef("v1.0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	r = newGitRepository("https://example.com/owner/repo", "", "", false, false)
+	r.meta = filepath.Join(root, "meta")
+	r.offline = true
+	defer r.Close()
+
+	refs, err := r.GetRefs()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(refs) || "master" != refs[0].Name() {
+		t.Error()
+	}
+	ref, err = r.GetRef("v1.0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if RefTag != ref.Kind() {
+		t.Error()
+	}
+	_, err = r.GetRef("v2.0")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+
+	ref, err = r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	entry, err := r.GetTreeEntry(ref, nil, "README")
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, err = r.GetBlobReader(entry)
+	if errOffline != err {
+		t.Error(err)
+	}
+}
+
+func TestClientOffline(t *testing.T) {
+	root, err := ioutil.TempDir("", "gitmeta_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	repos := filepath.Join(root, "repos")
+	testMakeLocalRepository(t, filepath.Join(repos, "owner", "repo.git"), nil)
+
+	open := func(offline bool) Client {
+		client, err := NewLocalClient(repos)
+		if nil != err {
+			t.Fatal(err)
+		}
+		config := []string{"config.dir=" + filepath.Join(root, "cache")}
+		if offline {
+			config = append(config, "config.offline=1")
+		}
+		_, err = client.SetConfig(config)
+		if nil != err {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	client := open(false)
+	owner, err := client.OpenOwner("owner")
+	if nil != err {
+		t.Fatal(err)
+	}
+	repository, err := client.OpenRepository(owner, "repo")
+	if nil != err {
+		t.Fatal(err)
+	}
+	client.CloseRepository(repository)
+	client.CloseOwner(owner)
+
+	os.RemoveAll(filepath.Join(repos, "owner"))
+
+	client = open(true)
+	owner, err = client.OpenOwner("owner")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.CloseOwner(owner)
+	repositories, err := client.GetRepositories(owner)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(repositories) || "repo" != repositories[0].Name() {
+		t.Error()
+	}
+
+	_, err = client.OpenOwner("other")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+}
+
+func TestClientMetadir(t *testing.T) {
+	for _, config := range [][]string{
+		{"config.dir=:"},
+		{"config.dir=:", "config.objdir=:"},
+		{"config.dir=/cache"},
+	} {
+		client, err := NewGenericClient("https://example.com/owner/repo", "", "")
+		if nil != err {
+			t.Fatal(err)
+		}
+		_, err = client.SetConfig(config)
+		if nil != err {
+			t.Fatal(err)
+		}
+		c := client.(*genericClient)
+		if "" == c.dir {
+			continue
+		}
+		/* only a persistent cache keeps metadata outside the cache directory */
+		inside := strings.HasPrefix(c.metadir, filepath.Join(c.dir, ".meta"))
+		if inside != (1 == len(config) || c.keepdir) {
+			t.Error(config, c.dir, c.metadir)
+		}
+	}
+}
+
+func TestClientOfflineObjects(t *testing.T) {
+	root, err := ioutil.TempDir("", "gitmeta_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "repos", "owner", "repo.git")
+	testMakeLocalRepository(t, path, nil)
+
+	client, err := NewGenericClient("https://example.com/owner/repo", "", "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, err = client.SetConfig([]string{"config.dir=:", "config.offline=1"})
+	if nil == err {
+		t.Error("offline mode accepted without persistent cache")
+	}
+
+	for _, config := range [][]string{
+		{"config.dir=" + filepath.Join(root, "cache")},
+		{"config.dir=" + filepath.Join(root, "temp"), "config.objdir=" + filepath.Join(root, "objects")},
+	} {
+		read := func(offline bool) {
+			client, err := NewGenericClient("https://example.com/owner/repo", "", "")
+			if nil != err {
+				t.Fatal(err)
+			}
+			c := append([]string{}, config...)
+			if offline {
+				c = append(c, "config.offline=1")
+			}
+			_, err = client.SetConfig(c)
+			if nil != err {
+				t.Fatal(err)
+			}
+			if "config.dir="+filepath.Join(root, "temp") == config[0] {
+				/* behave like the default cache directory, which is removed at unmount */
+				client.(*genericClient).keepdir = false
+				client.(*genericClient).metadir = filepath.Join(root, ".meta")
+			}
+			client.StartExpiration()
+			defer client.StopExpiration()
+
+			owner, err := client.OpenOwner("owner")
+			if nil != err {
+				t.Fatal(err)
+			}
+			defer client.CloseOwner(owner)
+			R, err := client.OpenRepository(owner, "repo")
+			if nil != err {
+				t.Fatal(err)
+			}
+			defer client.CloseRepository(R)
+			r := R.(*repository).Repository.(*gitRepository)
+			r.once.Do(func() {
+				if !offline {
+					repo, err := git.OpenLocalRepository(path)
+					if nil != err {
+						t.Fatal(err)
+					}
+					r.repo = repo
+				}
+			})
+
+			ref, err := R.GetRef("master")
+			if nil != err {
+				t.Fatal(err)
+			}
+			entry, err := R.GetTreeEntry(ref, nil, "README")
+			if nil != err {
+				t.Fatal(err)
+			}
+			reader, err := R.GetBlobReader(entry)
+			if nil != err {
+				t.Fatal(config, err)
+			}
+			content, err := ioutil.ReadAll(io.NewSectionReader(reader, 0, entry.Size()))
+			if closer, ok := reader.(io.Closer); ok {
+				closer.Close()
+			}
+			if nil != err || localFileContent != string(content) {
+				t.Error(config, err)
+			}
+		}
+
+		read(false)
+		read(true)
+	}
+}
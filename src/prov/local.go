@@ -0,0 +1,188 @@
+/*
+ * local.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+type LocalProvider struct {
+	Root   string
+	prefix string
+}
+
+// NewLocalProvider creates a provider for a local directory of git repositories laid
+// out as root/owner/repo[.git]. If the URI points to a repository, then the root is two
+// levels above it and the file system prefix is at owner/repo.
+func NewLocalProvider(uri *url.URL) Provider {
+	p := &LocalProvider{
+		Root: localPath(uri),
+	}
+	if _, ok := git.IsLocalRepository(p.Root); ok {
+		repo := strings.TrimSuffix(filepath.Base(p.Root), ".git")
+		owner := filepath.Base(filepath.Dir(p.Root))
+		p.Root = filepath.Dir(filepath.Dir(p.Root))
+		p.prefix = "/" + owner + "/" + repo
+	}
+	return p
+}
+
+func init() {
+	RegisterProviderClass("file:", NewLocalProvider, ""+
+		"file:///root[/owner/repo[.git]]\n"+
+		"    \taccess local directory of git repositories laid out as root/owner/repo[.git]\n"+
+		"    \t- owner     subdirectory of root\n"+
+		"    \t- repo      bare repository (or repository with .git) under owner")
+}
+
+func localPath(uri *url.URL) string {
+	path := uri.Path
+	if "windows" == runtime.GOOS && 3 <= len(path) && '/' == path[0] && ':' == path[2] {
+		path = path[1:]
+	}
+	return filepath.Clean(filepath.FromSlash(path))
+}
+
+func (p *LocalProvider) Auth() (token string, err error) {
+	// local repositories require no auth
+	return "", nil
+}
+
+func (p *LocalProvider) NewClient(token string) (Client, error) {
+	return NewLocalClient(p.Root)
+}
+
+func (p *LocalProvider) Prefix() string {
+	return p.prefix
+}
+
+type localClient struct {
+	client
+	ident string
+	root  string
+}
+
+func NewLocalClient(root string) (Client, error) {
+	info, err := os.Stat(root)
+	if nil != err {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, os.ErrInvalid
+	}
+
+	sum := sha256.Sum256([]byte(root))
+	c := &localClient{
+		ident: "file-" + hex.EncodeToString(sum[:6]),
+		root:  root,
+	}
+	c.client.init(c)
+
+	return c, nil
+}
+
+func (c *localClient) GetOwners() ([]Owner, error) {
+	infos, err := ioutil.ReadDir(c.root)
+	if nil != err {
+		return nil, err
+	}
+
+	res := make([]Owner, 0, len(infos))
+	for _, info := range infos {
+		n := info.Name()
+		if !info.IsDir() || strings.HasPrefix(n, ".") {
+			continue
+		}
+		if nil != c.filter && !c.filter.match(n) {
+			continue
+		}
+		res = append(res, &owner{FName: n})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+
+	return res, nil
+}
+
+func (c *localClient) getIdent() string {
+	return c.ident
+}
+
+func (c *localClient) getGitCredentials() (string, string) {
+	return "", ""
+}
+
+func (c *localClient) getOwner(o string) (res *owner, err error) {
+	defer trace(o)(&err)
+
+	if strings.ContainsAny(o, `/\`) || "." == o || ".." == o {
+		return nil, ErrNotFound
+	}
+
+	info, err := os.Stat(filepath.Join(c.root, o))
+	if nil != err || !info.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	res = &owner{
+		FName: o,
+		FKind: "directory",
+	}
+	res.Value = res
+	return
+}
+
+func (c *localClient) getRepositories(owner string, kind string) (res []*repository, err error) {
+	defer trace(owner)(&err)
+
+	dir := filepath.Join(c.root, owner)
+	infos, err := ioutil.ReadDir(dir)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]*repository, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		if _, ok := git.IsLocalRepository(path); !ok {
+			continue
+		}
+		remote := (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+		if "windows" == runtime.GOOS {
+			remote = (&url.URL{Scheme: "file", Path: "/" + filepath.ToSlash(path)}).String()
+		}
+		r := &repository{
+			FName:   strings.TrimSuffix(info.Name(), ".git"),
+			FRemote: remote,
+		}
+		r.Value = r
+		r.Repository = emptyRepository
+		r.keepdir = c.keepdir
+		res = append(res, r)
+	}
+
+	return res, nil
+}
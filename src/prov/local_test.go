@@ -0,0 +1,398 @@
+/*
+ * local_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	gitcache "github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/winfsp/hubfs/git"
+)
+
+const localFileContent = "hello, world\n"
+
+func testMakeLocalRepository(t *testing.T, path string, files map[string]string) {
+	_, err := gogit.PlainInit(path, true)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	storage := filesystem.NewStorage(osfs.New(path), gitcache.NewObjectLRUDefault())
+	defer storage.Close()
+
+	encode := func(fn func(obj plumbing.EncodedObject) error) plumbing.Hash {
+		obj := storage.NewEncodedObject()
+		err := fn(obj)
+		if nil != err {
+			t.Fatal(err)
+		}
+		hash, err := storage.SetEncodedObject(obj)
+		if nil != err {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	encodeBlob := func(content string) plumbing.Hash {
+		return encode(func(obj plumbing.EncodedObject) error {
+			obj.SetType(plumbing.BlobObject)
+			w, err := obj.Writer()
+			if nil != err {
+				return err
+			}
+			_, err = w.Write([]byte(content))
+			w.Close()
+			return err
+		})
+	}
+
+	blob := encodeBlob(localFileContent)
+	subentries := []object.TreeEntry{
+		{Name: "file", Mode: filemode.Regular, Hash: blob},
+	}
+	for n, c := range files {
+		if strings.HasPrefix(n, "dir/") {
+			subentries = append(subentries, object.TreeEntry{Name: n[len("dir/"):],
+				Mode: filemode.Regular, Hash: encodeBlob(c)})
+		}
+	}
+	sort.Slice(subentries, func(i, j int) bool { return subentries[i].Name < subentries[j].Name })
+	subtree := encode((&object.Tree{
+		Entries: subentries,
+	}).Encode)
+	entries := []object.TreeEntry{
+		{Name: "README", Mode: filemode.Regular, Hash: blob},
+		{Name: "dir", Mode: filemode.Dir, Hash: subtree},
+	}
+	for n, c := range files {
+		if !strings.HasPrefix(n, "dir/") {
+			entries = append(entries, object.TreeEntry{Name: n, Mode: filemode.Regular,
+				Hash: encodeBlob(c)})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	tree := encode((&object.Tree{
+		Entries: entries,
+	}).Encode)
+	sig := object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	commit := encode((&object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   "initial\n",
+		TreeHash:  tree,
+	}).Encode)
+
+	err = storage.SetReference(plumbing.NewHashReference("refs/heads/master", commit))
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = storage.SetReference(plumbing.NewHashReference("refs/tags/v1.0", commit))
+	if nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalClient(t *testing.T) {
+	root, err := ioutil.TempDir("", "local_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	testMakeLocalRepository(t, filepath.Join(root, "owner", "repo.git"), nil)
+
+	client, err := NewLocalClient(root)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	owners, err := client.GetOwners()
+	if nil != err {
+		t.Error(err)
+	}
+	if 1 != len(owners) || "owner" != owners[0].Name() {
+		t.Error()
+	}
+
+	owner, err := client.OpenOwner("owner")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.CloseOwner(owner)
+
+	repository, err := client.OpenRepository(owner, "repo")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.CloseRepository(repository)
+
+	refs, err := repository.GetRefs()
+	if nil != err {
+		t.Error(err)
+	}
+	if 1 != len(refs) || "master" != refs[0].Name() {
+		t.Error()
+	}
+
+	tag, err := repository.GetRef("v1.0")
+	if nil != err {
+		t.Error(err)
+	} else if RefTag != tag.Kind() {
+		t.Error()
+	}
+
+	_, err = repository.GetRef("v2.0")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+
+	ref, err := repository.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	entry, err := repository.GetTreeEntry(ref, nil, "dir")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	subentry, err := repository.GetTreeEntry(nil, entry, "file")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if int64(len(localFileContent)) != subentry.Size() {
+		t.Error()
+	}
+
+	reader, err := repository.GetBlobReader(subentry)
+	if nil != err {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader.(io.Reader))
+	reader.(io.Closer).Close()
+	if localFileContent != string(content) {
+		t.Error()
+	}
+
+	_, err = client.OpenRepository(owner, "nonexistent")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+}
+
+func TestLocalLfs(t *testing.T) {
+	root, err := ioutil.TempDir("", "local_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	lfsContent := "large file content\n"
+	sum := sha256.Sum256([]byte(lfsContent))
+	oid := hex.EncodeToString(sum[:])
+	pointer := fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n",
+		oid, len(lfsContent))
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, map[string]string{
+		".gitattributes":     "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"large.bin":          pointer,
+		"pointer.txt":        pointer,
+		"dir/.gitattributes": "*.txt filter=lfs\nkeep.bin -filter\n",
+		"dir/large.txt":      pointer,
+		"dir/keep.bin":       pointer,
+	})
+	p := git.LfsObjectPath(filepath.Join(path, "lfs", "objects"), oid)
+	os.MkdirAll(filepath.Dir(p), 0700)
+	err = ioutil.WriteFile(p, []byte(lfsContent), 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	client, err := NewLocalClient(root)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	owner, err := client.OpenOwner("owner")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.CloseOwner(owner)
+
+	repository, err := client.OpenRepository(owner, "repo")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.CloseRepository(repository)
+
+	ref, err := repository.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	entry, err := repository.GetTreeEntry(ref, nil, "large.bin")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if int64(len(lfsContent)) != entry.Size() {
+		t.Error()
+	}
+
+	reader, err := repository.GetBlobReader(entry)
+	if nil != err {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader.(io.Reader))
+	reader.(io.Closer).Close()
+	if lfsContent != string(content) {
+		t.Error()
+	}
+
+	entry, err = repository.GetTreeEntry(ref, nil, "README")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if int64(len(localFileContent)) != entry.Size() {
+		t.Error()
+	}
+
+	/* pointers are only resolved in files that match a filter=lfs rule */
+	entry, err = repository.GetTreeEntry(ref, nil, "pointer.txt")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if int64(len(pointer)) != entry.Size() {
+		t.Error()
+	}
+
+	dir, err := repository.GetTreeEntry(ref, nil, "dir")
+	if nil != err {
+		t.Fatal(err)
+	}
+	entry, err = repository.GetTreeEntry(ref, dir, "large.txt")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if int64(len(lfsContent)) != entry.Size() {
+		t.Error()
+	}
+	entry, err = repository.GetTreeEntry(ref, dir, "keep.bin")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if int64(len(pointer)) != entry.Size() {
+		t.Error()
+	}
+}
+
+func TestLocalRefresh(t *testing.T) {
+	root, err := ioutil.TempDir("", "local_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, nil)
+
+	repository, err := NewGitRepository("file://"+filepath.ToSlash(path), "", "", false, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	ref0, err := repository.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, err = repository.GetRef("v1.0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	temp0, err := repository.GetTempRef(ref0.(*gitRef).targetHash)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// move master to a new commit
+	storage := filesystem.NewStorage(osfs.New(path), gitcache.NewObjectLRUDefault())
+	parent, err := object.GetCommit(storage, plumbing.NewHash(ref0.(*gitRef).targetHash))
+	if nil != err {
+		t.Fatal(err)
+	}
+	obj := storage.NewEncodedObject()
+	sig := object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	err = (&object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      "second\n",
+		TreeHash:     parent.TreeHash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	}).Encode(obj)
+	if nil != err {
+		t.Fatal(err)
+	}
+	commit, err := storage.SetEncodedObject(obj)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = storage.SetReference(plumbing.NewHashReference("refs/heads/master", commit))
+	storage.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	ref, err := repository.GetRef("master")
+	if nil != err || ref != ref0 {
+		t.Error()
+	}
+
+	err = repository.RefreshRefs()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	ref, err = repository.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if ref == ref0 || commit.String() != ref.(*gitRef).targetHash {
+		t.Error()
+	}
+	temp, err := repository.GetTempRef(ref0.(*gitRef).targetHash)
+	if nil != err || temp != temp0 {
+		t.Error()
+	}
+	_, err = repository.GetRef("v1.0")
+	if nil != err {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,324 @@
+/*
+ * objcache.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+// objectCache limits the total size of the object files stored under a client's cache
+// directory. When the size exceeds the budget, the least recently used objects of all
+// repositories are evicted. Objects that back open files are never evicted.
+//
+// The methods of objectCache may be called on a nil receiver, in which case they do
+// nothing.
+type objectCache struct {
+	lock    sync.Mutex
+	once    sync.Once
+	root    string
+	budget  int64
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type objectCacheEntry struct {
+	path string
+	size int64
+	pins int
+}
+
+// parseCacheSize parses a size such as 500M, 20G or 1T.
+func parseCacheSize(s string) (int64, bool) {
+	mult := int64(1)
+	if 0 < len(s) {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		case "T":
+			mult = 1 << 40
+		}
+		if 1 != mult {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if nil != err || 0 > n {
+		return 0, false
+	}
+	return n * mult, true
+}
+
+// newObjectCache creates an object cache for the repositories under root. Objects
+// already present are not known to the cache until index is called.
+func newObjectCache(root string, budget int64) *objectCache {
+	return &objectCache{
+		root:    filepath.Clean(root),
+		budget:  budget,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// index adds the objects already present under root to the cache in order of
+// modification time, as least recently used. Only the first call does any work; the
+// directory walk is done without holding the lock, so that the cache may be used while
+// it is being indexed.
+func (oc *objectCache) index() {
+	if nil == oc {
+		return
+	}
+
+	oc.once.Do(oc.indexonce)
+}
+
+func (oc *objectCache) indexonce() {
+	type found struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	lst := []found{}
+	filepath.Walk(oc.root, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return nil
+		}
+		if info.IsDir() {
+			// skip overlay directories and repositories pending removal
+			if "files" == info.Name() || (path != oc.root && strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(info.Name(), ".") && oc.isObjectPath(path) {
+			lst = append(lst, found{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(lst, func(i, j int) bool { return lst[i].mtime.After(lst[j].mtime) })
+
+	oc.lock.Lock()
+	for _, f := range lst {
+		if _, ok := oc.entries[f.path]; ok {
+			continue
+		}
+		oc.entries[f.path] = oc.lru.PushBack(&objectCacheEntry{path: f.path, size: f.size})
+		oc.size += f.size
+	}
+	oc.lock.Unlock()
+
+	oc.evict()
+}
+
+// isObjectPath determines if path is an object file under root. Object files are
+// found in repository cache directories (owner/repo/objects/xx/...) or in a shared
+// object store (objects/xx/...); LFS objects are under lfs/objects/xx/yy/...
+func (oc *objectCache) isObjectPath(path string) bool {
+	rel, err := filepath.Rel(oc.root, path)
+	if nil != err || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	comp := strings.Split(filepath.ToSlash(rel), "/")
+	switch len(comp) {
+	case 3:
+		return "objects" == comp[0]
+	case 5:
+		return "objects" == comp[2] || ("lfs" == comp[0] && "objects" == comp[1])
+	case 7:
+		return "lfs" == comp[2] && "objects" == comp[3]
+	}
+	return false
+}
+
+// use records that an object was written or accessed.
+func (oc *objectCache) use(path string, size int64) {
+	if nil == oc {
+		return
+	}
+
+	oc.lock.Lock()
+	oc.touch(path, size)
+	victims := oc.victims()
+	oc.lock.Unlock()
+
+	oc.remove(victims)
+}
+
+func (oc *objectCache) touch(path string, size int64) *objectCacheEntry {
+	if elem, ok := oc.entries[path]; ok {
+		oc.lru.MoveToFront(elem)
+		return elem.Value.(*objectCacheEntry)
+	}
+	if !oc.isObjectPath(path) {
+		return nil
+	}
+	entry := &objectCacheEntry{path: path, size: size}
+	oc.entries[path] = oc.lru.PushFront(entry)
+	oc.size += size
+	return entry
+}
+
+// pin records that an object is backing an open file and returns a function that
+// must be called when the file is closed.
+func (oc *objectCache) pin(path string, size int64) func() {
+	if nil == oc {
+		return func() {}
+	}
+
+	oc.lock.Lock()
+	entry := oc.touch(path, size)
+	if nil != entry {
+		entry.pins++
+	}
+	victims := oc.victims()
+	oc.lock.Unlock()
+
+	oc.remove(victims)
+
+	var once sync.Once
+	return func() {
+		if nil == entry {
+			return
+		}
+		once.Do(func() {
+			oc.lock.Lock()
+			entry.pins--
+			victims := oc.victims()
+			oc.lock.Unlock()
+
+			oc.remove(victims)
+		})
+	}
+}
+
+// removeDir forgets all objects under dir, which is about to be removed.
+func (oc *objectCache) removeDir(dir string) {
+	if nil == oc {
+		return
+	}
+
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+
+	oc.lock.Lock()
+	for path, elem := range oc.entries {
+		if strings.HasPrefix(path, prefix) {
+			oc.size -= elem.Value.(*objectCacheEntry).size
+			oc.lru.Remove(elem)
+			delete(oc.entries, path)
+		}
+	}
+	oc.lock.Unlock()
+}
+
+// forget forgets an object that is about to be removed.
+func (oc *objectCache) forget(path string) {
+	if nil == oc {
+		return
+	}
+
+	oc.lock.Lock()
+	if elem, ok := oc.entries[path]; ok {
+		oc.size -= elem.Value.(*objectCacheEntry).size
+		oc.lru.Remove(elem)
+		delete(oc.entries, path)
+	}
+	oc.lock.Unlock()
+}
+
+func (oc *objectCache) evict() {
+	oc.lock.Lock()
+	victims := oc.victims()
+	oc.lock.Unlock()
+
+	oc.remove(victims)
+}
+
+// victims selects least recently used objects that are not pinned until the cache is
+// within budget. It must be called with the lock held.
+func (oc *objectCache) victims() (res []string) {
+	// the most recently used object is never evicted
+	for elem := oc.lru.Back(); nil != elem && elem != oc.lru.Front() && oc.size > oc.budget; {
+		prev := elem.Prev()
+		entry := elem.Value.(*objectCacheEntry)
+		if 0 == entry.pins {
+			oc.size -= entry.size
+			oc.lru.Remove(elem)
+			delete(oc.entries, entry.path)
+			res = append(res, entry.path)
+		}
+		elem = prev
+	}
+	return
+}
+
+func (oc *objectCache) remove(paths []string) {
+	for _, path := range paths {
+		err := os.Remove(path)
+		tracef("path=%#v [Remove() = %v]", path, err)
+	}
+}
+
+// cacheFile is an object file that is pinned in the object cache while it is open.
+type cacheFile struct {
+	*git.ObjectFile
+	release func()
+}
+
+func (f *cacheFile) Close() error {
+	f.release()
+	return f.ObjectFile.Close()
+}
+
+// openObject opens an object file and pins it in the object cache. The object is pinned
+// before it is opened, so that it cannot be evicted in between. A newly stored object
+// should be opened before its use is recorded for the same reason.
+func (oc *objectCache) openObject(path string) (*cacheFile, error) {
+	return oc.open(path, git.OpenObjectFile)
+}
+
+// openLfsObject opens an (uncompressed) LFS object file and pins it in the object cache.
+func (oc *objectCache) openLfsObject(path string) (*cacheFile, error) {
+	return oc.open(path, git.OpenRawObjectFile)
+}
+
+func (oc *objectCache) open(path string,
+	openfn func(path string) (*git.ObjectFile, error)) (*cacheFile, error) {
+	info, err := os.Stat(path)
+	if nil != err {
+		return nil, err
+	}
+	release := oc.pin(path, info.Size())
+	file, err := openfn(path)
+	if nil != err {
+		release()
+		if os.IsNotExist(err) {
+			oc.forget(path)
+		}
+		return nil, err
+	}
+	return &cacheFile{ObjectFile: file, release: release}, nil
+}
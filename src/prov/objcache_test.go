@@ -0,0 +1,247 @@
+/*
+ * objcache_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+func TestParseCacheSize(t *testing.T) {
+	expect := func(s string, en int64, eok bool) {
+		n, ok := parseCacheSize(s)
+		if en != n || eok != ok {
+			t.Errorf("size %q expect (%v, %v) got (%v, %v)", s, en, eok, n, ok)
+		}
+	}
+
+	expect("1000", 1000, true)
+	expect("10K", 10<<10, true)
+	expect("500m", 500<<20, true)
+	expect("20G", 20<<30, true)
+	expect("1T", 1<<40, true)
+	expect("", 0, false)
+	expect("G", 0, false)
+	expect("-1G", 0, false)
+	expect("10X", 0, false)
+}
+
+func TestObjectCache(t *testing.T) {
+	root, err := ioutil.TempDir("", "objcache_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	write := func(rel string, size int, mtime time.Time) string {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0700)
+		err := ioutil.WriteFile(p, make([]byte, size), 0600)
+		if nil != err {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, mtime, mtime)
+		return p
+	}
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return nil == err
+	}
+
+	now := time.Now()
+	p0 := write("o/r/objects/00/0000", 100, now.Add(-4*time.Hour))
+	p1 := write("o/r/lfs/objects/11/11/1111", 100, now.Add(-3*time.Hour))
+	p2 := write("o/s/lfs/objects/22/22/2222", 100, now.Add(-2*time.Hour))
+	p3 := write("o/s/objects/33/3333", 100, now.Add(-1*time.Hour))
+	f0 := write("o/r/files/master/00/0000", 1000, now.Add(-5*time.Hour))
+
+	oc := newObjectCache(root, 350)
+	oc.index()
+	if exists(p0) || !exists(p1) || !exists(p2) || !exists(p3) || !exists(f0) {
+		t.Error()
+	}
+	if 300 != oc.size {
+		t.Error(oc.size)
+	}
+
+	_, err = oc.openObject(filepath.Join(root, "o", "r", "objects", "77", "7777"))
+	if nil == err || 3 != oc.lru.Len() {
+		t.Error(err)
+	}
+
+	file, err := oc.openLfsObject(p1)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	p4 := write("o/s/lfs/objects/44/44/4444", 100, now)
+	oc.use(p4, 100)
+	if !exists(p1) || exists(p2) || !exists(p3) || !exists(p4) {
+		t.Error()
+	}
+
+	p5 := write("o/s/objects/55/5555", 100, now)
+	oc.use(p5, 100)
+	if !exists(p1) || exists(p3) || !exists(p4) || !exists(p5) {
+		t.Error()
+	}
+
+	file.Close()
+	file.Close()
+	p6 := write("o/r/objects/66/6666", 100, now)
+	oc.use(p6, 100)
+	if exists(p1) || !exists(p4) || !exists(p5) || !exists(p6) {
+		t.Error()
+	}
+	if 300 != oc.size {
+		t.Error(oc.size)
+	}
+
+	oc.removeDir(filepath.Join(root, "o", "s"))
+	if 100 != oc.size || 1 != oc.lru.Len() {
+		t.Error()
+	}
+
+	var nilcache *objectCache
+	nilcache.use(p4, 100)
+	file, err = nilcache.openLfsObject(p4)
+	if nil != err {
+		t.Fatal(err)
+	}
+	file.Close()
+}
+
+func TestSharedObjectStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "objcache_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, nil)
+
+	store := filepath.Join(root, "store")
+	err = os.Mkdir(store, 0750)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	open := func(name string, online bool) *gitRepository {
+		r := newGitRepository("https://example.com/owner/"+name, "", "", false, false)
+		r.once.Do(func() {
+			if online {
+				repo, err := git.OpenLocalRepository(path)
+				if nil != err {
+					t.Fatal(err)
+				}
+				r.repo = repo
+			}
+		})
+		r.meta = filepath.Join(root, "meta", name)
+		r.offline = !online
+		r.store, r.storeperm = store, 0050
+		err := r.SetDirectory(filepath.Join(root, "cache", name))
+		if nil != err {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	read := func(r *gitRepository, commit string) (res string) {
+		ref, err := r.GetTempRef(commit)
+		if nil != err {
+			t.Fatal(err)
+		}
+		entry, err := r.GetTreeEntry(ref, nil, "README")
+		if nil != err {
+			t.Fatal(err)
+		}
+		reader, err := r.GetBlobReader(entry)
+		if nil != err {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(reader.(io.Reader))
+		reader.(io.Closer).Close()
+		return string(content)
+	}
+
+	r := open("repo", true)
+	ref, err := r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	commit := ref.(*gitRef).targetHash
+	if localFileContent != read(r, commit) {
+		t.Error()
+	}
+	r.Close()
+
+	// a fork that has never been fetched finds the objects in the shared store
+	fork := open("fork", false)
+	defer fork.Close()
+	if localFileContent != read(fork, commit) {
+		t.Error()
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cache", "repo", "objects")); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+	if "windows" != runtime.GOOS {
+		info, err := os.Stat(objectPath(store, commit))
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 0640 != info.Mode().Perm() {
+			t.Error(info.Mode())
+		}
+		info, err = os.Stat(filepath.Dir(objectPath(store, commit)))
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 0750 != info.Mode().Perm() {
+			t.Error(info.Mode())
+		}
+
+		// directories writable by group or other are sticky; objects are never writable
+		r := open("shared", false)
+		defer r.Close()
+		r.storeperm = 0077
+		p := objectPath(store, "ff00000000000000000000000000000000000000")
+		os.MkdirAll(filepath.Dir(p), 0700)
+		ioutil.WriteFile(p, nil, 0600)
+		r.storeObject(store, p, 0)
+		info, err = os.Stat(filepath.Dir(p))
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 0777 != info.Mode().Perm() || 0 == info.Mode()&os.ModeSticky {
+			t.Error(info.Mode())
+		}
+		info, err = os.Stat(p)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 0644 != info.Mode().Perm() {
+			t.Error(info.Mode())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * objverify.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+// Object files in the cache are verified against their hash when they are first used
+// from disk; an object file that was truncated or corrupted (e.g. after a crash or a
+// disk error) is removed and the object is refetched. Objects that are fetched are
+// verified while they are written and need no further verification. An object file
+// that is replaced after it has been verified (i.e. it has a different inode, size or
+// modification time) is verified again.
+
+var errCorrupted = errors.New("corrupted object file")
+
+func (r *gitRepository) isVerified(p string) bool {
+	r.vlock.Lock()
+	info := r.verified[p]
+	r.vlock.Unlock()
+	if nil == info {
+		return false
+	}
+	curr, err := os.Stat(p)
+	return nil == err && os.SameFile(info, curr) &&
+		info.Size() == curr.Size() && info.ModTime().Equal(curr.ModTime())
+}
+
+func (r *gitRepository) setVerified(p string) {
+	info, err := os.Stat(p)
+	if nil != err {
+		return
+	}
+	r.vlock.Lock()
+	defer r.vlock.Unlock()
+	if nil == r.verified {
+		r.verified = make(map[string]os.FileInfo)
+	}
+	r.verified[p] = info
+}
+
+// checkObject verifies the object file p of type ot (0 if unknown) on first use. A
+// corrupted object file is removed.
+func (r *gitRepository) checkObject(p string, hash string, ot git.ObjectType,
+	reader io.ReaderAt, size int64) bool {
+	if r.isVerified(p) {
+		return true
+	}
+	if !git.VerifyObject(reader, size, ot, hash) {
+		r.discardObject(p)
+		return false
+	}
+	r.setVerified(p)
+	return true
+}
+
+// checkLfsObject verifies the LFS object file p on first use. A corrupted object file
+// is removed.
+func (r *gitRepository) checkLfsObject(p string, pointer *git.LfsPointer, file *cacheFile) bool {
+	if r.isVerified(p) {
+		return true
+	}
+	if pointer.Size != file.Size() || !git.VerifyLfsObject(file, file.Size(), pointer.Oid) {
+		r.discardObject(p)
+		return false
+	}
+	r.setVerified(p)
+	return true
+}
+
+func (r *gitRepository) discardObject(p string) {
+	r.objcache.forget(p)
+	err := os.Remove(p)
+	tracef("path=%#v [Remove() = %v]", p, err)
+}
+
+// ScrubCache verifies the object files under dir, which may be a cache directory, a
+// shared object store or a directory that contains them. Corrupted object files are
+// removed, so that their objects are refetched when next used; fn (if not nil) is called
+// for each of them. ScrubCache returns the number of object files verified and removed.
+func ScrubCache(dir string, fn func(path string)) (count int, corrupted int, err error) {
+	dir = filepath.Clean(dir)
+	_, err = os.Stat(dir)
+	if nil != err {
+		return
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return nil
+		}
+		if info.IsDir() {
+			// skip overlay directories, metadata and repositories pending removal
+			if path != dir && ("files" == info.Name() ||
+				(strings.HasPrefix(info.Name(), ".") && ".objects" != info.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		hash, lfs := scrubObjectHash(path)
+		if "" == hash {
+			return nil
+		}
+
+		ok := false
+		if lfs {
+			if file, err := git.OpenRawObjectFile(path); nil == err {
+				ok = git.VerifyLfsObject(file, file.Size(), hash)
+				file.Close()
+			}
+		} else {
+			if file, err := git.OpenObjectFile(path); nil == err {
+				ok = git.VerifyObject(file, file.Size(), file.Type(), hash)
+				file.Close()
+			}
+		}
+		count++
+		if !ok {
+			if nil == os.Remove(path) {
+				corrupted++
+				if nil != fn {
+					fn(path)
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// scrubObjectHash returns the object hash for an object file path (objects/xx/yyyy) or
+// the oid for an LFS object file path (lfs/objects/xx/yy/oid).
+func scrubObjectHash(path string) (hash string, lfs bool) {
+	name := filepath.Base(path)
+	dir1 := filepath.Dir(path)
+	dir2 := filepath.Dir(dir1)
+	if 38 == len(name) && 2 == len(filepath.Base(dir1)) && "objects" == filepath.Base(dir2) {
+		hash = filepath.Base(dir1) + name
+	} else if 64 == len(name) && name[2:4] == filepath.Base(dir1) && name[:2] == filepath.Base(dir2) &&
+		"objects" == filepath.Base(filepath.Dir(dir2)) {
+		hash, lfs = name, true
+	}
+	if _, err := hex.DecodeString(hash); nil != err {
+		return "", false
+	}
+	return
+}
@@ -0,0 +1,130 @@
+/*
+ * objverify_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+func TestObjectVerification(t *testing.T) {
+	root, err := ioutil.TempDir("", "objverify_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, nil)
+
+	cache := filepath.Join(root, "cache")
+	open := func() *gitRepository {
+		r := newGitRepository("https://example.com/owner/repo", "", "", false, false)
+		r.once.Do(func() {
+			repo, err := git.OpenLocalRepository(path)
+			if nil != err {
+				t.Fatal(err)
+			}
+			r.repo = repo
+		})
+		err := r.SetDirectory(filepath.Join(cache, "owner", "repo"))
+		if nil != err {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	read := func(r *gitRepository) (hash string, res string) {
+		ref, err := r.GetRef("master")
+		if nil != err {
+			t.Fatal(err)
+		}
+		entry, err := r.GetTreeEntry(ref, nil, "README")
+		if nil != err {
+			t.Fatal(err)
+		}
+		reader, err := r.GetBlobReader(entry)
+		if nil != err {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(reader.(io.Reader))
+		reader.(io.Closer).Close()
+		return entry.Hash(), string(content)
+	}
+
+	r := open()
+	hash, content := read(r)
+	if localFileContent != content {
+		t.Error()
+	}
+	r.Close()
+
+	// a corrupted object file is detected on first use and refetched
+	p := objectPath(filepath.Join(cache, "owner", "repo"), hash)
+	err = ioutil.WriteFile(p, []byte("corrupted"), 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+	r = open()
+	_, content = read(r)
+	if localFileContent != content {
+		t.Error()
+	}
+
+	// an object file that is replaced after it has been verified is verified again
+	err = ioutil.WriteFile(p+".tmp", []byte("replaced"), 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = os.Rename(p+".tmp", p)
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, content = read(r)
+	if localFileContent != content {
+		t.Error()
+	}
+	r.Close()
+
+	count, corrupted, err := ScrubCache(cache, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0 == count || 0 != corrupted {
+		t.Error(count, corrupted)
+	}
+
+	err = ioutil.WriteFile(p, []byte("corrupted"), 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+	removed := []string{}
+	count2, corrupted, err := ScrubCache(cache, func(path string) {
+		removed = append(removed, path)
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if count != count2 || 1 != corrupted || 1 != len(removed) || p != removed[0] {
+		t.Error(count2, corrupted, removed)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
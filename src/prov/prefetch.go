@@ -0,0 +1,161 @@
+/*
+ * prefetch.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+// prefetchBatch is the maximum number of blobs that are fetched with a single request.
+const prefetchBatch = 1000
+
+// blobPrefetcher is implemented by repositories that can fetch many blobs with a single
+// request. The function fn is called with the index of each entry after it is fetched or
+// fails to be fetched.
+type blobPrefetcher interface {
+	prefetchBlobs(entries []TreeEntry, fn func(i int, err error))
+}
+
+// getBlobPrefetcher returns the blobPrefetcher of a repository (nil if none). Repositories
+// opened through a client are unwrapped.
+func getBlobPrefetcher(R Repository) blobPrefetcher {
+	if r, ok := R.(*repository); ok {
+		R = r.Repository
+	}
+	res, _ := R.(blobPrefetcher)
+	return res
+}
+
+// PrefetchTree fetches the trees and blobs under path (the root of the ref if empty)
+// into the repository cache. Trees are walked in order and blobs are fetched in batches
+// by jobs concurrent workers. Submodules are not walked. The function fn (if not nil) is
+// called for each blob after it is fetched or fails to be fetched; it may be called
+// concurrently. PrefetchTree returns the first error encountered.
+func PrefetchTree(repository Repository, ref Ref, pathname string, jobs int,
+	fn func(pathname string, size int64, err error)) (err error) {
+	defer trace(pathname, jobs)(&err)
+
+	if 0 >= jobs {
+		jobs = 1
+	}
+
+	var lock sync.Mutex
+	var ferr error
+	first := func(e error) {
+		lock.Lock()
+		if nil == ferr {
+			ferr = e
+		}
+		lock.Unlock()
+	}
+
+	prefetcher := getBlobPrefetcher(repository)
+	fetch := func(entries []TreeEntry, fn func(i int, err error)) {
+		if nil != prefetcher {
+			prefetcher.prefetchBlobs(entries, fn)
+			return
+		}
+		for i, entry := range entries {
+			reader, e := repository.GetBlobReader(entry)
+			if c, ok := reader.(io.Closer); ok {
+				c.Close()
+			}
+			fn(i, e)
+		}
+	}
+
+	type batch struct {
+		pathnames []string
+		entries   []TreeEntry
+	}
+	queue := make(chan *batch, jobs)
+	var wg sync.WaitGroup
+	for i := 0; jobs > i; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range queue {
+				b := b
+				fetch(b.entries, func(i int, e error) {
+					if nil != fn {
+						fn(b.pathnames[i], b.entries[i].Size(), e)
+					}
+					if nil != e {
+						first(e)
+					}
+				})
+			}
+		}()
+	}
+
+	curr := &batch{}
+	var walk func(pathname string, entry TreeEntry) error
+	walk = func(pathname string, entry TreeEntry) error {
+		if nil != entry {
+			switch entry.Mode() & 0170000 {
+			case 0040000:
+			case 0100000:
+				curr.pathnames = append(curr.pathnames, pathname)
+				curr.entries = append(curr.entries, entry)
+				if prefetchBatch <= len(curr.entries) {
+					queue <- curr
+					curr = &batch{}
+				}
+				return nil
+			default:
+				return nil
+			}
+		}
+
+		lst, e := repository.GetTree(ref, entry)
+		if nil != e {
+			return e
+		}
+		for _, e := range lst {
+			e := walk(path.Join(pathname, e.Name()), e)
+			if nil != e {
+				return e
+			}
+		}
+		return nil
+	}
+
+	var entry TreeEntry
+	for _, c := range strings.Split(pathname, "/") {
+		if "" == c {
+			continue
+		}
+		entry, err = repository.GetTreeEntry(ref, entry, c)
+		if nil != err {
+			break
+		}
+	}
+	if nil == err {
+		err = walk(strings.Trim(pathname, "/"), entry)
+	}
+	if nil == err && 0 < len(curr.entries) {
+		queue <- curr
+	}
+
+	close(queue)
+	wg.Wait()
+
+	if nil == err {
+		err = ferr
+	}
+	return
+}
@@ -0,0 +1,142 @@
+/*
+ * prefetch_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+// countingRemote counts the requests that fetch object files.
+type countingRemote struct {
+	gitRemote
+	lock  sync.Mutex
+	count int
+}
+
+func (r *countingRemote) FetchObjectFiles(wants []string, dir string,
+	fn func(hash string, ot git.ObjectType) error) error {
+	r.lock.Lock()
+	r.count++
+	r.lock.Unlock()
+	return r.gitRemote.FetchObjectFiles(wants, dir, fn)
+}
+
+func TestPrefetchTree(t *testing.T) {
+	root, err := ioutil.TempDir("", "prefetch_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, map[string]string{
+		"a.txt": "a\n",
+		"b.txt": "b\n",
+	})
+
+	open := func(online bool) *gitRepository {
+		r := newGitRepository("https://example.com/owner/repo", "", "", false, false)
+		r.once.Do(func() {
+			if online {
+				repo, err := git.OpenLocalRepository(path)
+				if nil != err {
+					t.Fatal(err)
+				}
+				r.repo = &countingRemote{gitRemote: repo}
+			}
+		})
+		r.meta = filepath.Join(root, "meta")
+		r.offline = !online
+		err := r.SetDirectory(filepath.Join(root, "cache"))
+		if nil != err {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := open(true)
+	ref, err := r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	commit := ref.(*gitRef).targetHash
+
+	var lock sync.Mutex
+	fetched := []string{}
+	err = PrefetchTree(r, ref, "", 4, func(pathname string, size int64, err error) {
+		if nil != err {
+			t.Error(pathname, err)
+		}
+		lock.Lock()
+		fetched = append(fetched, pathname)
+		lock.Unlock()
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	sort.Strings(fetched)
+	if 4 != len(fetched) ||
+		"README" != fetched[0] || "a.txt" != fetched[1] || "b.txt" != fetched[2] || "dir/file" != fetched[3] {
+		t.Error(fetched)
+	}
+	if 1 != r.repo.(*countingRemote).count {
+		t.Error("blobs not fetched in a single request", r.repo.(*countingRemote).count)
+	}
+
+	fetched = fetched[:0]
+	err = PrefetchTree(r, ref, "dir", 4, func(pathname string, size int64, err error) {
+		fetched = append(fetched, pathname)
+	})
+	if nil != err || 1 != len(fetched) || "dir/file" != fetched[0] {
+		t.Error(err, fetched)
+	}
+
+	err = PrefetchTree(r, ref, "nonexistent", 4, nil)
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+	r.Close()
+
+	// all content must now be available without the remote
+	r = open(false)
+	defer r.Close()
+	ref, err = r.GetTempRef(commit)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"README", "a.txt", "b.txt"} {
+		entry, err := r.GetTreeEntry(ref, nil, n)
+		if nil != err {
+			t.Fatal(err)
+		}
+		reader, err := r.GetBlobReader(entry)
+		if nil != err {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(reader.(io.Reader))
+		reader.(io.Closer).Close()
+		if ("README" == n && localFileContent != string(content)) ||
+			("a.txt" == n && "a\n" != string(content)) {
+			t.Error(n)
+		}
+	}
+}
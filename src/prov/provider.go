@@ -14,10 +14,14 @@
 package prov
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +33,12 @@ type Provider interface {
 	NewClient(token string) (Client, error)
 }
 
+// PrefixProvider is implemented by providers whose file system prefix does not
+// correspond to the path of the remote URI (e.g. git remotes, local repositories).
+type PrefixProvider interface {
+	Prefix() string
+}
+
 type Client interface {
 	SetConfig(config []string) ([]string, error)
 	GetDirectory() string
@@ -53,12 +63,16 @@ type Repository interface {
 	RemoveDirectory() error
 	Name() string
 	GetRefs() ([]Ref, error)
+	RefreshRefs() error
 	GetRef(name string) (Ref, error)
 	GetTempRef(name string) (Ref, error)
 	GetTree(ref Ref, entry TreeEntry) ([]TreeEntry, error)
 	GetTreeEntry(ref Ref, entry TreeEntry, name string) (TreeEntry, error)
 	GetBlobReader(entry TreeEntry) (io.ReaderAt, error)
 	GetModule(ref Ref, path string, rootrel bool) (string, error)
+	GetGitDir(ref Ref) (*GitDir, error)
+	GetObject(hash string) (string, []byte, error)
+	PushChanges(ref Ref, changes []*Change, info *PushInfo) (string, error)
 }
 
 type Ref interface {
@@ -75,6 +89,45 @@ type TreeEntry interface {
 	Hash() string
 }
 
+// GitDir describes the git repository state of a ref: the remote, the HEAD (a full ref
+// name or a commit hash), the commit of the ref and the branches and tags (full ref
+// names to hashes).
+type GitDir struct {
+	Remote string
+	Head   string
+	Commit string
+	Refs   map[string]string
+}
+
+// Change describes a change to a file or directory relative to the tree of a ref.
+type Change struct {
+	Path   string     // slash separated path relative to the ref root
+	Kind   ChangeKind // kind of change
+	Entry  TreeEntry  // entry in the tree of the ref (nil for added files)
+	Mode   uint32     // git mode of the new file (0 for deleted files)
+	Hash   string     // blob hash of the new file
+	Target string     // target of a new symlink
+	File   string     // local file with the content of a new regular file
+}
+
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeModified
+	ChangeDeleted
+	ChangeOpaque // directory deleted and recreated; its content in the ref is deleted
+)
+
+// PushInfo describes the commit created by PushChanges and the branch it is pushed to.
+type PushInfo struct {
+	Base    string // commit that the changes were made on (empty for the commit of the ref)
+	Branch  string // branch name (empty for the branch of the ref)
+	Name    string // author name
+	Email   string // author email
+	Message string // commit message
+}
+
 type RefKind int
 
 const (
@@ -91,6 +144,7 @@ var ErrNotFound = errors.New("not found")
 var regmutex sync.RWMutex
 var registry = make(map[string]func(uri *url.URL) Provider)
 var reghelp = make(map[string]string)
+var kindregistry = make(map[string]func(hostname string, config map[string]string) Provider)
 
 func RegisterProviderClass(name string, ctor func(uri *url.URL) Provider, help string) {
 	regmutex.Lock()
@@ -116,6 +170,144 @@ func GetProviderClassHelp(name string) string {
 	return reghelp[name]
 }
 
+// RegisterProviderKind registers a provider kind. A provider kind can be used to create
+// provider classes for arbitrary hosts (e.g. GitHub Enterprise, self-managed GitLab).
+func RegisterProviderKind(kind string, ctor func(hostname string, config map[string]string) Provider) {
+	regmutex.Lock()
+	defer regmutex.Unlock()
+	kindregistry[kind] = ctor
+}
+
+func GetProviderKindNames() (names []string) {
+	regmutex.RLock()
+	defer regmutex.RUnlock()
+	names = make([]string, 0, len(kindregistry))
+	for n := range kindregistry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return
+}
+
+// RegisterProviderInstance registers a provider class for hostname using a provider kind.
+// The config map may contain provider specific keys such as "api", "clientid",
+// "clientsecret", "callback" and "scopes".
+func RegisterProviderInstance(hostname string, kind string, config map[string]string) error {
+	regmutex.RLock()
+	kctor := kindregistry[kind]
+	regmutex.RUnlock()
+	if nil == kctor {
+		return errors.New("unknown provider kind: " + kind)
+	}
+	if "" == hostname || strings.ContainsAny(hostname, "/:") {
+		return errors.New("invalid provider hostname: " + hostname)
+	}
+
+	ctor := func(uri *url.URL) Provider {
+		return kctor(hostname, config)
+	}
+	RegisterProviderClass(hostname, ctor, fmt.Sprintf(""+
+		"[https://]%s[/owner[/repo]]\n"+
+		"    \taccess %s (%s)\n"+
+		"    \t- owner     file system root is at owner\n"+
+		"    \t- repo      file system root is at owner/repo",
+		hostname, hostname, kind))
+	return nil
+}
+
+func providerConfigValue(config map[string]string, k string, v string) string {
+	if s, ok := config[k]; ok {
+		return s
+	}
+	return v
+}
+
+// RegisterProviderInstanceSpec registers a provider class from a specification of the form
+// hostname=kind[,key=value...]. If the specification starts with @ then the remainder is
+// a file name and the file contains one specification per line.
+func RegisterProviderInstanceSpec(spec string) error {
+	if strings.HasPrefix(spec, "@") {
+		file, err := os.Open(spec[1:])
+		if nil != err {
+			return err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if "" == line || strings.HasPrefix(line, "#") {
+				continue
+			}
+			err = RegisterProviderInstanceSpec(line)
+			if nil != err {
+				return err
+			}
+		}
+
+		return scanner.Err()
+	}
+
+	list := strings.Split(spec, ",")
+	i := strings.IndexByte(list[0], '=')
+	if -1 == i {
+		return errors.New("invalid provider spec: " + spec)
+	}
+	hostname, kind := list[0][:i], list[0][i+1:]
+	config := make(map[string]string)
+	for _, s := range list[1:] {
+		i = strings.IndexByte(s, '=')
+		if -1 == i {
+			return errors.New("invalid provider spec: " + spec)
+		}
+		config[s[:i]] = s[i+1:]
+	}
+
+	return RegisterProviderInstance(hostname, kind, config)
+}
+
+// ParseRemote parses a remote URI. In addition to URIs it accepts the scp-like syntax
+// [user@]host:path used by SSH remotes, for which it returns an ssh URI whose path is kept
+// as given: it is relative to the home directory of the ssh user (e.g. owner/repo or
+// ~/repo) unless it starts with a slash. Use RemoteString to format the result.
+func ParseRemote(remote string) (*url.URL, error) {
+	if !strings.Contains(remote, "://") {
+		i := strings.IndexRune(remote, ':')
+		j := strings.IndexRune(remote, '/')
+		k := strings.IndexRune(remote, '@') + 1
+		// host must be longer than one character to distinguish it from a drive letter
+		if -1 != i && (-1 == j || i < j) && 1 < i-k {
+			return &url.URL{
+				Scheme: "ssh",
+				User:   userInfo(remote[:k]),
+				Host:   remote[k:i],
+				Path:   remote[i+1:],
+			}, nil
+		}
+	}
+	return url.Parse(remote)
+}
+
+// RemoteString formats a remote parsed by ParseRemote. An ssh remote with a relative path
+// is formatted using the scp-like syntax, because an ssh:// URI cannot express it.
+func RemoteString(uri *url.URL) string {
+	if "ssh" == uri.Scheme && !strings.HasPrefix(uri.Path, "/") {
+		user := ""
+		if nil != uri.User {
+			user = uri.User.Username() + "@"
+		}
+		return user + uri.Host + ":" + uri.Path
+	}
+	return uri.String()
+}
+
+func userInfo(s string) *url.Userinfo {
+	if "" == s {
+		return nil
+	}
+	return url.User(strings.TrimSuffix(s, "@"))
+}
+
 func GetProviderInstanceName(uri *url.URL) string {
 	regmutex.RLock()
 	defer regmutex.RUnlock()
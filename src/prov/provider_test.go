@@ -0,0 +1,93 @@
+/*
+ * provider_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRegisterProviderInstanceSpec(t *testing.T) {
+	err := RegisterProviderInstanceSpec(
+		"ghe.test.example=github,api=https://ghe.test.example/api/v3,clientid=XXXX")
+	if nil != err {
+		t.Error(err)
+	}
+
+	uri, _ := url.Parse("https://ghe.test.example/owner")
+	if "ghe.test.example" != GetProviderInstanceName(uri) {
+		t.Error()
+	}
+	p, ok := NewProviderInstance(uri).(*GithubProvider)
+	if !ok {
+		t.Error()
+	} else if "https://ghe.test.example/api/v3" != p.ApiURI || "XXXX" != p.ClientId {
+		t.Error()
+	}
+
+	err = RegisterProviderInstanceSpec("gitlab.test.example=gitlab")
+	if nil != err {
+		t.Error(err)
+	}
+
+	uri, _ = url.Parse("https://gitlab.test.example")
+	q, ok := NewProviderInstance(uri).(*GitlabProvider)
+	if !ok {
+		t.Error()
+	} else if "https://gitlab.test.example/api/v4" != q.ApiURI || "" != q.ClientId {
+		t.Error()
+	}
+	_, err = q.Auth()
+	if nil == err {
+		t.Error()
+	}
+
+	err = RegisterProviderInstanceSpec("host.test.example=nonexistent")
+	if nil == err {
+		t.Error()
+	}
+	err = RegisterProviderInstanceSpec("host.test.example")
+	if nil == err {
+		t.Error()
+	}
+	err = RegisterProviderInstanceSpec("host.test.example=github,api")
+	if nil == err {
+		t.Error()
+	}
+}
+
+func TestParseRemote(t *testing.T) {
+	expect := func(remote string, eresult string) {
+		uri, err := ParseRemote(remote)
+		if nil != err || eresult != RemoteString(uri) {
+			t.Errorf("remote %q expect %q got (%v, %v)", remote, eresult, uri, err)
+		}
+	}
+
+	expect("git@github.com:owner/repo.git", "git@github.com:owner/repo.git")
+	expect("example.com:owner/repo", "example.com:owner/repo")
+	expect("git@example.com:~/repo", "git@example.com:~/repo")
+	expect("example.com:repo", "example.com:repo")
+	expect("example.com:/srv/git/repo", "ssh://example.com/srv/git/repo")
+	expect("ssh://git@example.com:2222/owner/repo", "ssh://git@example.com:2222/owner/repo")
+	expect("https://example.com/owner/repo", "https://example.com/owner/repo")
+	expect("github.com/owner", "github.com/owner")
+	expect("C:/path/repo", "c:/path/repo")
+
+	uri, err := ParseRemote("git@example.com:~/repo")
+	if nil != err || "ssh" != uri.Scheme || "example.com" != uri.Host || "git" != uri.User.Username() ||
+		"~/repo" != uri.Path {
+		t.Error(uri, err)
+	}
+}
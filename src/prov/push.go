@@ -0,0 +1,264 @@
+/*
+ * push.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+var ErrNoChanges = errors.New("no changes")
+
+var (
+	errNotBranch = errors.New("ref is not a branch")
+	errLfsChange = errors.New("changes to LFS files cannot be pushed")
+)
+
+// HashFile computes the blob hash of a local file and determines if its content is the
+// same as that of a tree entry (which may be nil). The content of an LFS file is compared
+// with its LFS object rather than its pointer.
+func HashFile(path string, entry TreeEntry) (hash string, same bool, err error) {
+	file, err := os.Open(path)
+	if nil != err {
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if nil != err {
+		return
+	}
+
+	hash, err = git.HashObject(git.BlobObject, info.Size(), file)
+	if nil != err || nil == entry {
+		return
+	}
+
+	if hash == entry.Hash() {
+		same = true
+	} else if e, ok := entry.(*gitTreeEntry); ok && nil != e.pointer {
+		same = git.VerifyLfsObject(file, info.Size(), e.pointer.Oid)
+	}
+	return
+}
+
+// pushTree is a tree that is being modified. Subtrees are loaded from the ref when they
+// are modified; unmodified subtrees are kept as entries.
+type pushTree struct {
+	entries map[string]*git.TreeEntry
+	lower   map[string]TreeEntry
+	trees   map[string]*pushTree
+}
+
+func (r *gitRepository) newPushTree(ref Ref, entry TreeEntry) (*pushTree, error) {
+	t := &pushTree{
+		entries: make(map[string]*git.TreeEntry),
+		lower:   make(map[string]TreeEntry),
+		trees:   make(map[string]*pushTree),
+	}
+	if nil == entry && nil == ref {
+		return t, nil
+	}
+	lst, err := r.GetTree(ref, entry)
+	if nil != err {
+		return nil, err
+	}
+	for _, e := range lst {
+		t.entries[e.Name()] = &git.TreeEntry{Name: e.Name(), Mode: e.Mode(), Hash: e.Hash()}
+		t.lower[e.Name()] = e
+	}
+	return t, nil
+}
+
+// subtree returns the subtree with the specified name, replacing any file.
+func (r *gitRepository) subtree(ref Ref, t *pushTree, name string) (res *pushTree, err error) {
+	if res, ok := t.trees[name]; ok {
+		return res, nil
+	}
+	if e, ok := t.entries[name]; ok && 0040000 == e.Mode {
+		res, err = r.newPushTree(ref, t.lower[name])
+	} else {
+		res, err = r.newPushTree(nil, nil)
+	}
+	if nil != err {
+		return
+	}
+	t.entries[name] = &git.TreeEntry{Name: name, Mode: 0040000}
+	t.trees[name] = res
+	return
+}
+
+// encode encodes a tree and its modified subtrees into objects and returns the hash and
+// number of entries of the tree. Empty subtrees are omitted, because git does not record
+// empty directories.
+func (t *pushTree) encode(objects map[string]*git.Object) (hash string, count int, err error) {
+	lst := make([]*git.TreeEntry, 0, len(t.entries))
+	for n, e := range t.entries {
+		if s, ok := t.trees[n]; ok {
+			h, c, err := s.encode(objects)
+			if nil != err {
+				return "", 0, err
+			}
+			if 0 == c {
+				continue
+			}
+			e = &git.TreeEntry{Name: n, Mode: 0040000, Hash: h}
+		}
+		lst = append(lst, e)
+	}
+
+	content, err := git.EncodeTree(lst)
+	if nil != err {
+		return
+	}
+	hash, obj := git.NewObject(git.TreeObject, content)
+	objects[hash] = obj
+	return hash, len(lst), nil
+}
+
+// PushChanges creates a commit that applies changes to the tree of the base commit (by
+// default the commit of the ref) and pushes it to a branch. The branch must either not
+// exist or be at the base commit; changes are never rebased onto a branch that has moved.
+func (r *gitRepository) PushChanges(ref0 Ref, changes []*Change, info *PushInfo) (
+	commit string, err error) {
+	ref, ok := ref0.(*gitRef)
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	err = r.ensureOpen()
+	if nil != err {
+		return
+	}
+
+	gitdir, err := r.GetGitDir(ref)
+	if nil != err {
+		return
+	}
+
+	branch := info.Branch
+	if "" == branch {
+		if !strings.HasPrefix(gitdir.Head, "refs/heads/") {
+			return "", errNotBranch
+		}
+		branch = gitdir.Head
+	} else if !strings.HasPrefix(branch, "refs/") {
+		branch = "refs/heads/" + branch
+	}
+
+	base := strings.ToLower(info.Base)
+	if "" == base {
+		base = gitdir.Commit
+	}
+
+	refs, err := r.repo.ListRefs([]string{branch})
+	if nil != err {
+		return
+	}
+	oldhash := refs[branch]
+	if "" != oldhash && base != oldhash {
+		return "", git.ErrRefChanged
+	}
+
+	// the changes apply to the tree of the base commit
+	if base != gitdir.Commit {
+		ref0, err = r.GetTempRef(base)
+		if nil != err {
+			return
+		}
+		ref = ref0.(*gitRef)
+	}
+
+	_, content, err := r.GetObject(base)
+	if nil != err {
+		return
+	}
+	c, err := git.DecodeCommit(content)
+	if nil != err {
+		return
+	}
+
+	objects := make(map[string]*git.Object)
+	root, err := r.newPushTree(ref, nil)
+	if nil != err {
+		return
+	}
+	for _, change := range changes {
+		t := root
+		names := strings.Split(change.Path, "/")
+		for _, n := range names[:len(names)-1] {
+			t, err = r.subtree(ref, t, n)
+			if nil != err {
+				return
+			}
+		}
+		n := names[len(names)-1]
+
+		if ChangeDeleted == change.Kind || ChangeOpaque == change.Kind {
+			delete(t.entries, n)
+			delete(t.trees, n)
+			continue
+		}
+
+		if e, ok := change.Entry.(*gitTreeEntry); ok && nil != e.pointer {
+			return "", errLfsChange
+		}
+		if 0120000 == change.Mode {
+			content = []byte(change.Target)
+		} else {
+			content, err = ioutil.ReadFile(change.File)
+			if nil != err {
+				return
+			}
+		}
+		hash, obj := git.NewObject(git.BlobObject, content)
+		objects[hash] = obj
+		t.entries[n] = &git.TreeEntry{Name: n, Mode: change.Mode, Hash: hash}
+		delete(t.trees, n)
+	}
+
+	tree, _, err := root.encode(objects)
+	if nil != err {
+		return
+	}
+	if tree == c.TreeHash {
+		return "", ErrNoChanges
+	}
+
+	message := info.Message
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	sig := git.Signature{Name: info.Name, Email: info.Email, Time: time.Now()}
+	content, err = git.EncodeCommit(tree, []string{base}, sig, sig, message)
+	if nil != err {
+		return
+	}
+	commit, obj := git.NewObject(git.CommitObject, content)
+	objects[commit] = obj
+
+	err = r.repo.Push(branch, oldhash, commit, objects)
+	if nil != err {
+		return "", err
+	}
+
+	r.RefreshRefs()
+
+	return commit, nil
+}
@@ -0,0 +1,206 @@
+/*
+ * push_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+func TestPushChanges(t *testing.T) {
+	root, err := ioutil.TempDir("", "push_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "owner", "repo.git")
+	testMakeLocalRepository(t, path, nil)
+
+	r := newGitRepository("https://example.com/owner/repo", "", "", false, false)
+	r.once.Do(func() {
+		repo, err := git.OpenLocalRepository(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		r.repo = repo
+	})
+	r.meta = filepath.Join(root, "meta")
+	err = r.SetDirectory(filepath.Join(root, "cache"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ref, err := r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	readme, err := r.GetTreeEntry(ref, nil, "README")
+	if nil != err {
+		t.Fatal(err)
+	}
+	dir, err := r.GetTreeEntry(ref, nil, "dir")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(root, "file")
+	err = ioutil.WriteFile(file, []byte("new content\n"), 0644)
+	if nil != err {
+		t.Fatal(err)
+	}
+	hash, same, err := HashFile(file, readme)
+	if nil != err || same {
+		t.Error(hash, same, err)
+	}
+	info := &PushInfo{Branch: "feature", Name: "test", Email: "test@example.com", Message: "update"}
+
+	_, err = r.PushChanges(ref, nil, info)
+	if ErrNoChanges != err {
+		t.Error(err)
+	}
+
+	commit, err := r.PushChanges(ref, []*Change{
+		{Path: "README", Kind: ChangeModified, Entry: readme, Mode: 0100644, Hash: hash, File: file},
+		{Path: "dir", Kind: ChangeDeleted, Entry: dir},
+		{Path: "new/sub/file", Kind: ChangeAdded, Mode: 0100755, Hash: hash, File: file},
+		{Path: "link", Kind: ChangeAdded, Mode: 0120000, Target: "README"},
+	}, info)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	ref, err = r.GetRef("feature")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if commit != ref.(*gitRef).targetHash {
+		t.Error(commit)
+	}
+	tree, err := r.GetTree(ref, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	names := map[string]TreeEntry{}
+	for _, e := range tree {
+		names[e.Name()] = e
+	}
+	if 3 != len(names) || nil == names["README"] || nil == names["new"] || nil == names["link"] ||
+		hash != names["README"].Hash() || 0120000 != names["link"].Mode() {
+		t.Error(names)
+	}
+	entry, err := r.GetTreeEntry(ref, names["new"], "sub")
+	if nil != err {
+		t.Fatal(err)
+	}
+	entry, err = r.GetTreeEntry(ref, entry, "file")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0100755 != entry.Mode() {
+		t.Error(entry.Mode())
+	}
+	reader, err := r.GetBlobReader(entry)
+	if nil != err {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(reader.(io.Reader))
+	reader.(io.Closer).Close()
+	if "new content\n" != string(content) {
+		t.Error(string(content))
+	}
+
+	// the branch of a ref is pushed to by default; it must not have moved
+	ref, err = r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	info.Branch = "feature"
+	_, err = r.PushChanges(ref, []*Change{{Path: "README", Kind: ChangeDeleted, Entry: readme}}, info)
+	if git.ErrRefChanged != err {
+		t.Error(err)
+	}
+	info.Branch = ""
+	base := ref.(*gitRef).targetHash
+	_, err = r.PushChanges(ref, []*Change{{Path: "README", Kind: ChangeDeleted, Entry: readme}}, info)
+	if nil != err {
+		t.Error(err)
+	}
+
+	// changes made on a commit are not pushed once the branch has moved from it, even
+	// if the ref has been refreshed since
+	ref, err = r.GetRef("master")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if base == ref.(*gitRef).targetHash {
+		t.Error()
+	}
+	info.Base = base
+	_, err = r.PushChanges(ref, []*Change{
+		{Path: "README", Kind: ChangeModified, Entry: readme, Mode: 0100644, Hash: hash, File: file},
+	}, info)
+	if git.ErrRefChanged != err {
+		t.Error(err)
+	}
+
+	// changes are applied to the tree of the base commit
+	info.Base = ref.(*gitRef).targetHash
+	info.Branch = "other"
+	commit, err = r.PushChanges(ref, []*Change{
+		{Path: "extra", Kind: ChangeAdded, Mode: 0100644, Hash: hash, File: file},
+	}, info)
+	if nil != err {
+		t.Fatal(err)
+	}
+	info.Base = base
+	info.Branch = "other2"
+	commit2, err := r.PushChanges(ref, []*Change{{Path: "dir", Kind: ChangeDeleted, Entry: dir}}, info)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, c := range []string{commit, commit2} {
+		_, content, err := r.GetObject(c)
+		if nil != err {
+			t.Fatal(err)
+		}
+		decoded, err := git.DecodeCommit(content)
+		if nil != err {
+			t.Fatal(err)
+		}
+		_, content, err = r.GetObject(decoded.TreeHash)
+		if nil != err {
+			t.Fatal(err)
+		}
+		entries, err := git.DecodeTree(content)
+		if nil != err {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		if expect := map[string]string{commit: "dir,extra", commit2: "README"}[c]; expect !=
+			strings.Join(names, ",") {
+			t.Error(c, names)
+		}
+	}
+}
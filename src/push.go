@@ -0,0 +1,161 @@
+/*
+ * push.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/git"
+	"github.com/winfsp/hubfs/prov"
+)
+
+func init() {
+	commands["push"] = command{push, "[options] [remote] owner/repo@ref",
+		"commit the changes made to a ref directory and push them to a branch"}
+}
+
+/* changeLetter returns the letter that denotes a kind of change (as in git status --short) */
+func changeLetter(kind prov.ChangeKind) string {
+	switch kind {
+	case prov.ChangeAdded:
+		return "A"
+	case prov.ChangeModified:
+		return "M"
+	case prov.ChangeDeleted:
+		return "D"
+	case prov.ChangeOpaque:
+		return "O"
+	}
+	return "?"
+}
+
+/* gitConfig returns a value from the git configuration of the user */
+func gitConfig(name string) string {
+	out, err := exec.Command("git", "config", "--get", name).Output()
+	if nil != err {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func push(args []string) int {
+	var refopt refOptions
+	branch := ""
+	message := ""
+	remote := "github.com"
+	spec := ""
+
+	flags := flag.NewFlagSet(progname+" push", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s push %s\n\n", progname, commands["push"].args)
+		fmt.Fprintf(os.Stderr, ""+
+			"The author is specified with -o config.author.name=NAME,config.author.email=EMAIL\n"+
+			"or is taken from the git configuration (user.name, user.email).\n\n")
+		flags.PrintDefaults()
+	}
+	refopt.addFlags(flags)
+	flags.StringVar(&branch, "b", branch, "`branch` to push to; default: the branch of the ref")
+	flags.StringVar(&message, "m", message, "commit `message`")
+	if nil != flags.Parse(args) {
+		return 2
+	}
+
+	switch flags.NArg() {
+	case 1:
+		spec = flags.Arg(0)
+	case 2:
+		remote = flags.Arg(0)
+		spec = flags.Arg(1)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if _, _, _, path, ok := parseRefSpec(spec); !ok || "" != path || "" == message {
+		flags.Usage()
+		return 2
+	}
+
+	rs, ok := refopt.openRefSpec(remote, spec)
+	if !ok {
+		return 1
+	}
+	defer rs.close()
+
+	info := &prov.PushInfo{
+		Branch:  branch,
+		Message: message,
+	}
+	for _, s := range rs.config {
+		if strings.HasPrefix(s, "config.author.name=") {
+			info.Name = s[len("config.author.name="):]
+		} else if strings.HasPrefix(s, "config.author.email=") {
+			info.Email = s[len("config.author.email="):]
+		}
+	}
+	if "" == info.Name {
+		info.Name = gitConfig("user.name")
+	}
+	if "" == info.Email {
+		info.Email = gitConfig("user.email")
+	}
+	if "" == info.Name || "" == info.Email {
+		warn("author unknown: use -o config.author.name=NAME,config.author.email=EMAIL")
+		return 1
+	}
+
+	var changes []*prov.Change
+	base, err := hubfs.OverlayBase(rs.repository, rs.ref)
+	if nil == err {
+		changes, err = hubfs.OverlayChanges(rs.repository, rs.ref, base, rs.caseins)
+	}
+	if nil != err {
+		warn("overlay error: %v", err)
+		return 1
+	}
+	for _, c := range changes {
+		fmt.Printf("%s %s\n", changeLetter(c.Kind), c.Path)
+	}
+
+	info.Base = base
+	commit, err := rs.repository.PushChanges(rs.ref, changes, info)
+	if prov.ErrNoChanges == err {
+		fmt.Printf("%s: no changes to push\n", spec)
+		return 0
+	}
+	if git.ErrRefChanged == err {
+		warn("push error: the branch has moved from commit %s that the changes were made on", base)
+		return 1
+	}
+	if nil != err {
+		warn("push error: %v", err)
+		return 1
+	}
+	fmt.Printf("%s: pushed commit %s\n", spec, commit)
+
+	/* the changes are now part of the branch of the ref */
+	if "" == branch {
+		err = hubfs.SetOverlayBase(rs.repository, rs.ref, commit)
+		if nil != err {
+			warn("overlay error: %v", err)
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,144 @@
+/*
+ * refspec.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"runtime"
+	"strings"
+
+	libtrace "github.com/billziss-gh/golib/trace"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+/* refOptions are the options of commands that open a ref without mounting */
+type refOptions struct {
+	debug    bool
+	authmeth string
+	authkey  string
+	provspec util.Optlist
+	options  util.Optlist
+}
+
+func (o *refOptions) addFlags(flags *flag.FlagSet) {
+	o.authmeth = "full"
+	flags.BoolVar(&o.debug, "d", o.debug, "debug output")
+	flags.StringVar(&o.authmeth, "auth", o.authmeth, "auth `method` (see main usage)")
+	flags.StringVar(&o.authkey, "authkey", o.authkey, "`name` of key that stores auth token in system keyring")
+	flags.Var(&o.provspec, "provider", "register provider for additional host using `spec` (see main usage)")
+	flags.Var(&o.options, "o", "config `options` (e.g. config.dir=DIR); must match those used when mounting")
+}
+
+/* refSpec is a ref opened from a spec of the form owner/repo@ref[:path] */
+type refSpec struct {
+	client     prov.Client
+	owner      prov.Owner
+	repository prov.Repository
+	ref        prov.Ref
+	path       string
+	caseins    bool
+	config     []string /* config options not used by the client */
+}
+
+/* parseRefSpec parses a spec of the form owner/repo@ref[:path] */
+func parseRefSpec(spec string) (owner string, repo string, ref string, path string, ok bool) {
+	repospec := spec
+	if i := strings.Index(spec, "@"); -1 != i {
+		repospec, ref = spec[:i], spec[i+1:]
+	}
+	if i := strings.Index(ref, ":"); -1 != i {
+		ref, path = ref[:i], ref[i+1:]
+	}
+	names := strings.Split(repospec, "/")
+	if 2 != len(names) || "" == names[0] || "" == names[1] || "" == ref {
+		return
+	}
+	return names[0], names[1], ref, path, true
+}
+
+/* openRefSpec opens the ref of a spec; the spec must have been validated by parseRefSpec */
+func (o *refOptions) openRefSpec(remote string, spec string) (res *refSpec, ok bool) {
+	ownername, reponame, refname, path, _ := parseRefSpec(spec)
+
+	if o.debug {
+		libtrace.Verbose = true
+		libtrace.Pattern = "*,github.com/winfsp/hubfs/*,github.com/winfsp/hubfs/fs/*"
+	}
+
+	for _, s := range o.provspec {
+		err := prov.RegisterProviderInstanceSpec(s)
+		if nil != err {
+			warn("provider error: %v", err)
+			return
+		}
+	}
+
+	uri, _, client, ok := newClient(remote, o.authmeth, o.authkey)
+	if !ok {
+		return
+	}
+
+	res = &refSpec{client: client, path: path}
+	config := []string{"config.dir=:"}
+	if "ssh" == uri.Scheme {
+		config = append(config, "config.ssh=1")
+	}
+	if "windows" == runtime.GOOS || "darwin" == runtime.GOOS {
+		config = append(config, "config._caseins=1")
+		res.caseins = true
+	}
+	for _, o := range o.options {
+		config = append(config, strings.Split(o, ",")...)
+	}
+	var err error
+	res.config, err = client.SetConfig(config)
+	if nil != err {
+		warn("config error: %v", err)
+		return nil, false
+	}
+
+	/*
+	 * Expiration is not started, so the cache directory is left in place for a
+	 * subsequent mount to use.
+	 */
+	res.owner, err = client.OpenOwner(ownername)
+	if nil != err {
+		warn("owner %s: %v", ownername, err)
+		return nil, false
+	}
+	res.repository, err = client.OpenRepository(res.owner, reponame)
+	if nil != err {
+		warn("repository %s/%s: %v", ownername, reponame, err)
+		client.CloseOwner(res.owner)
+		return nil, false
+	}
+
+	res.ref, err = res.repository.GetRef(refname)
+	if prov.ErrNotFound == err {
+		res.ref, err = res.repository.GetTempRef(refname)
+	}
+	if nil != err {
+		warn("ref %s: %v", refname, err)
+		res.close()
+		return nil, false
+	}
+
+	return res, true
+}
+
+func (s *refSpec) close() {
+	s.client.CloseRepository(s.repository)
+	s.client.CloseOwner(s.owner)
+}
@@ -0,0 +1,75 @@
+/*
+ * reset.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/winfsp/hubfs/fs/hubfs"
+)
+
+func init() {
+	commands["reset"] = command{reset, "[options] [remote] owner/repo@ref[:path]",
+		"discard the changes made to a ref directory (or to a path within it)"}
+}
+
+func reset(args []string) int {
+	var refopt refOptions
+	remote := "github.com"
+	spec := ""
+
+	flags := flag.NewFlagSet(progname+" reset", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s reset %s\n\n", progname, commands["reset"].args)
+		fmt.Fprintf(os.Stderr, ""+
+			"The ref must not be in use by a mounted file system. To reset a ref of a\n"+
+			"mounted file system write the paths to reset to the file REF/.reset instead.\n\n")
+		flags.PrintDefaults()
+	}
+	refopt.addFlags(flags)
+	if nil != flags.Parse(args) {
+		return 2
+	}
+
+	switch flags.NArg() {
+	case 1:
+		spec = flags.Arg(0)
+	case 2:
+		remote = flags.Arg(0)
+		spec = flags.Arg(1)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if _, _, _, _, ok := parseRefSpec(spec); !ok {
+		flags.Usage()
+		return 2
+	}
+
+	rs, ok := refopt.openRefSpec(remote, spec)
+	if !ok {
+		return 1
+	}
+	defer rs.close()
+
+	err := hubfs.ResetOverlay(rs.repository, rs.ref, rs.caseins, hubfs.ParseResetPaths(rs.path))
+	if nil != err {
+		warn("reset error: %v", err)
+		return 1
+	}
+
+	return 0
+}
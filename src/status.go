@@ -0,0 +1,89 @@
+/*
+ * status.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/prov"
+)
+
+func init() {
+	commands["status"] = command{status, "[options] [remote] owner/repo@ref[:path]",
+		"list the files added, modified and deleted in a ref directory"}
+}
+
+func status(args []string) int {
+	var refopt refOptions
+	remote := "github.com"
+	spec := ""
+
+	flags := flag.NewFlagSet(progname+" status", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s status %s\n\n", progname, commands["status"].args)
+		fmt.Fprintf(os.Stderr, ""+
+			"Each change is listed as: A (added), M (modified), D (deleted) or\n"+
+			"O (opaque: directory deleted and recreated) followed by the path.\n\n")
+		flags.PrintDefaults()
+	}
+	refopt.addFlags(flags)
+	if nil != flags.Parse(args) {
+		return 2
+	}
+
+	switch flags.NArg() {
+	case 1:
+		spec = flags.Arg(0)
+	case 2:
+		remote = flags.Arg(0)
+		spec = flags.Arg(1)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if _, _, _, _, ok := parseRefSpec(spec); !ok {
+		flags.Usage()
+		return 2
+	}
+
+	rs, ok := refopt.openRefSpec(remote, spec)
+	if !ok {
+		return 1
+	}
+	defer rs.close()
+
+	var changes []*prov.Change
+	base, err := hubfs.OverlayBase(rs.repository, rs.ref)
+	if nil == err {
+		changes, err = hubfs.OverlayChanges(rs.repository, rs.ref, base, rs.caseins)
+	}
+	if nil != err {
+		warn("overlay error: %v", err)
+		return 1
+	}
+
+	prefix := strings.Trim(rs.path, "/")
+	for _, c := range changes {
+		if "" != prefix && c.Path != prefix && !strings.HasPrefix(c.Path, prefix+"/") {
+			continue
+		}
+		fmt.Printf("%s %s\n", changeLetter(c.Kind), c.Path)
+	}
+
+	return 0
+}